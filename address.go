@@ -0,0 +1,254 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Address is a parsed S7 address of a value, or of an array of values, in the
+// memory of a PLC. It is read, written or batched as one item.
+type Address struct {
+	Area     int // one of the Area constants
+	DBNumber int
+	Start    int // byte offset, the number of the first timer or counter
+	Bit      int // bit of a BOOL
+	Type     DataType
+	Length   int // characters of a STRING or WSTRING
+	Count    int // elements of an array, 0 for a single value
+}
+
+var (
+	dbAddressPattern    = regexp.MustCompile(`^DB(\d+)\.DB([XBWD])(\d+)(?:\.(\d+))?$`)
+	areaAddressPattern  = regexp.MustCompile(`^(PE|PA|PI|PQ|E|A|I|Q|M)([XBWD]?)(\d+)(?:\.(\d+))?$`)
+	tcAddressPattern    = regexp.MustCompile(`^(T|C|Z)(\d+)$`)
+	typeSuffixPattern   = regexp.MustCompile(`^([A-Z0-9_]+)(?:\[(\d+)\])?$`)
+	areaMnemonics       = map[string]int{"PE": s7areap, "PA": s7areap, "PI": s7areap, "PQ": s7areap, "E": s7areape, "I": s7areape, "A": s7areapa, "Q": s7areapa, "M": s7areamk}
+	sizeDesignatorTypes = map[string]DataType{"": TypeBool, "X": TypeBool, "B": TypeByte, "W": TypeWord, "D": TypeDWord}
+)
+
+// ParseAddress parses an address in German or English mnemonics with an
+// optional type suffix, which is case insensitive:
+//
+//	DB1.DBX0.3  DB1.DBB10  DB1.DBW2  DB1.DBD4   data block bit, byte, word, dword
+//	E0.1 I0.1 EB0 IW2 AD4 QB0 MW2 M10.3          inputs, outputs, merkers
+//	PEW256 PIW256 PAB0 PQD8                      peripheral inputs and outputs
+//	T5 C3 Z3                                     timer, counter
+//
+// The suffix gives the type of the value at a byte, word or dword address, the
+// length of a string or the elements of an array:
+//
+//	DB10.DBD4:REAL  MW2:INT  DB1.DBB10:STRING[20]  DB5.DBW0:INT[10]  DB1.DBX0.0:BOOL[16]
+func ParseAddress(s string) (a Address, err error) {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	main, suffix, typed := strings.Cut(s, ":")
+	invalid := func(reason string) error {
+		return fmt.Errorf("s7: invalid address %q: %s", s, reason)
+	}
+
+	var designator, bit string
+	if m := dbAddressPattern.FindStringSubmatch(main); m != nil {
+		a.Area = s7areadb
+		a.DBNumber, _ = strconv.Atoi(m[1])
+		designator, bit = m[2], m[4]
+		a.Start, _ = strconv.Atoi(m[3])
+	} else if m := areaAddressPattern.FindStringSubmatch(main); m != nil {
+		a.Area = areaMnemonics[m[1]]
+		designator, bit = m[2], m[4]
+		a.Start, _ = strconv.Atoi(m[3])
+		if a.Area == s7areap && (designator == "" || designator == "X") {
+			return a, invalid("the peripheral area is accessed by byte, word or dword")
+		}
+	} else if m := tcAddressPattern.FindStringSubmatch(main); m != nil {
+		a.Area, a.Type = s7areact, TypeCounter
+		if m[1] == "T" {
+			a.Area, a.Type = s7areatm, TypeTimer
+		}
+		a.Start, _ = strconv.Atoi(m[2])
+	} else {
+		return a, invalid("unknown area")
+	}
+	if a.Area != s7areact && a.Area != s7areatm {
+		a.Type = sizeDesignatorTypes[designator]
+		if (a.Type == TypeBool) != (bit != "") {
+			return a, invalid("a bit address needs the bit, others none")
+		}
+		if a.Bit, _ = strconv.Atoi(bit); a.Bit > 7 {
+			return a, invalid("bit must be 0-7")
+		}
+	}
+
+	if typed {
+		m := typeSuffixPattern.FindStringSubmatch(suffix)
+		if m == nil {
+			return a, invalid("malformed type")
+		}
+		t, ok := parseDataType(m[1])
+		if !ok {
+			return a, invalid("unknown type " + m[1])
+		}
+		n := -1
+		if m[2] != "" {
+			n, _ = strconv.Atoi(m[2])
+		}
+		if err = a.setType(t, n); err != nil {
+			return a, invalid(err.Error())
+		}
+	}
+	return a, nil
+}
+
+// setType sets the type of the suffix, n is its bracketed number or -1.
+func (a *Address) setType(t DataType, n int) error {
+	switch {
+	case t == TypeString || t == TypeWString:
+		a.Length = maxStringLength
+		if n >= 0 {
+			a.Length = n
+		}
+		if a.Length > maxStringLength && t == TypeString || a.Length > 16382 {
+			return fmt.Errorf("%v of %d characters is too long", t, a.Length)
+		}
+	case n == 0:
+		return fmt.Errorf("an array needs elements")
+	case n > 0:
+		a.Count = n
+	}
+	switch {
+	case a.Area == s7areatm || a.Area == s7areact:
+		if t != a.Type {
+			return fmt.Errorf("%v is not a type of the area", t)
+		}
+	case a.Type == TypeBool || t == TypeBool:
+		if t != a.Type {
+			return fmt.Errorf("%v needs a byte address", t)
+		}
+	case t == TypeTimer || t == TypeCounter:
+		return fmt.Errorf("%v is a type of its area", t)
+	case a.Type == TypeWord && t.Size(a.Length) != 2 || a.Type == TypeDWord && t.Size(a.Length) != 4:
+		return fmt.Errorf("%v does not fit a %v address", t, a.Type)
+	}
+	a.Type = t
+	return nil
+}
+
+// String returns the address in English mnemonics, with a type suffix unless the
+// size of the address implies the type.
+func (a Address) String() string {
+	var s, designator string
+	switch a.Area {
+	case s7areadb:
+		s = fmt.Sprintf("DB%d.DB", a.DBNumber)
+		designator = "X"
+	case s7areape:
+		s = "I"
+	case s7areapa:
+		s = "Q"
+	case s7areamk:
+		s = "M"
+	case s7areap:
+		s = "PI"
+	case s7areatm:
+		s = fmt.Sprintf("T%d", a.Start)
+	case s7areact:
+		s = fmt.Sprintf("C%d", a.Start)
+	default:
+		s = fmt.Sprintf("Area(0x%02X)", a.Area)
+	}
+	implied := a.Type
+	switch {
+	case a.Area == s7areatm || a.Area == s7areact:
+	case a.Type == TypeBool:
+		s += fmt.Sprintf("%s%d.%d", designator, a.Start, a.Bit)
+	case a.Type.Size(a.Length) == 2 && a.Type != TypeString:
+		s += fmt.Sprintf("W%d", a.Start)
+		implied = TypeWord
+	case a.Type.Size(a.Length) == 4 && a.Type != TypeWString:
+		s += fmt.Sprintf("D%d", a.Start)
+		implied = TypeDWord
+	default:
+		s += fmt.Sprintf("B%d", a.Start)
+		implied = TypeByte
+	}
+	switch {
+	case a.Type == TypeString || a.Type == TypeWString:
+		s += fmt.Sprintf(":%v[%d]", a.Type, a.Length)
+	case a.Count > 0:
+		s += fmt.Sprintf(":%v[%d]", a.Type, a.Count)
+	case a.Type != implied:
+		s += ":" + a.Type.String()
+	}
+	return s
+}
+
+// Size returns the bytes of the value at the address.
+func (a Address) Size() int {
+	count := max(a.Count, 1)
+	if a.Type == TypeBool {
+		return (a.Bit + count + 7) / 8
+	}
+	return count * a.Type.Size(a.Length)
+}
+
+// DataItem returns the item to read the address into buffer with AGReadMulti.
+// buffer must have Size bytes.
+func (a Address) DataItem(buffer []byte) S7DataItem {
+	item := S7DataItem{Area: a.Area, DBNumber: a.DBNumber, Start: a.Start, WordLen: s7wlbyte, Amount: a.Size(), Data: buffer}
+	switch a.Area {
+	case s7areatm:
+		item.WordLen, item.Amount = s7wltimer, max(a.Count, 1)
+	case s7areact:
+		item.WordLen, item.Amount = s7wlcounter, max(a.Count, 1)
+	}
+	return item
+}
+
+// goType returns the Go type of the values at the address, which its zero bytes
+// decode to.
+func (a Address) goType() (reflect.Type, error) {
+	v, err := a.Decode(make([]byte, a.Size()))
+	if err != nil {
+		return nil, err
+	}
+	return reflect.TypeOf(v), nil
+}
+
+// Decode decodes the value at the address from its bytes, see the types for the
+// Go types of the values. Arrays are decoded to slices, like []int16 for INT.
+func (a Address) Decode(b []byte) (interface{}, error) {
+	if a.Count > 0 {
+		return decodeValues(a.Type, a.Length, a.Count, a.Bit, b)
+	}
+	if a.Type == TypeBool {
+		if len(b) == 0 {
+			return nil, fmt.Errorf("s7: no byte for %v", a)
+		}
+		return b[0]&(1<<a.Bit) != 0, nil
+	}
+	return decodeValue(a.Type, a.Length, b)
+}
+
+// Encode encodes value to the bytes of the address. Values convert to the type
+// if they fit, e.g. any Go integer to INT, arrays take a slice of Count values.
+// A BOOL encodes to one byte, 0 or 1, per bit as the bits are written one by one.
+func (a Address) Encode(value interface{}) (b []byte, err error) {
+	if a.Type == TypeBool {
+		b = make([]byte, max(a.Count, 1))
+	} else {
+		b = make([]byte, a.Size())
+	}
+	if a.Count > 0 {
+		err = encodeValues(a.Type, a.Length, a.Count, value, b)
+	} else {
+		err = encodeValue(a.Type, a.Length, value, b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,182 @@
+package gos7
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseAddress(t *testing.T) {
+	input := []struct {
+		in   string
+		out  Address
+		size int
+		str  string
+	}{
+		{"DB1.DBX0.3", Address{Area: AreaDB, DBNumber: 1, Bit: 3, Type: TypeBool}, 1, "DB1.DBX0.3"},
+		{"db10.dbd4:real", Address{Area: AreaDB, DBNumber: 10, Start: 4, Type: TypeReal}, 4, "DB10.DBD4:REAL"},
+		{"DB1.DBB10:STRING[20]", Address{Area: AreaDB, DBNumber: 1, Start: 10, Type: TypeString, Length: 20}, 22, "DB1.DBB10:STRING[20]"},
+		{"DB5.DBW0:INT[10]", Address{Area: AreaDB, DBNumber: 5, Type: TypeInt, Count: 10}, 20, "DB5.DBW0:INT[10]"},
+		{"DB1.DBX0.6:BOOL[4]", Address{Area: AreaDB, DBNumber: 1, Bit: 6, Type: TypeBool, Count: 4}, 2, "DB1.DBX0.6:BOOL[4]"},
+		{"MW2:INT", Address{Area: AreaMK, Start: 2, Type: TypeInt}, 2, "MW2:INT"},
+		{"M10.3", Address{Area: AreaMK, Start: 10, Bit: 3, Type: TypeBool}, 1, "M10.3"},
+		{"E0.1", Address{Area: AreaPE, Bit: 1, Type: TypeBool}, 1, "I0.1"},
+		{"EW4", Address{Area: AreaPE, Start: 4, Type: TypeWord}, 2, "IW4"},
+		{"AD8", Address{Area: AreaPA, Start: 8, Type: TypeDWord}, 4, "QD8"},
+		{"QB0", Address{Area: AreaPA, Type: TypeByte}, 1, "QB0"},
+		{"PEW256", Address{Area: AreaP, Start: 256, Type: TypeWord}, 2, "PIW256"},
+		{"MB0:LREAL", Address{Area: AreaMK, Type: TypeLReal}, 8, "MB0:LREAL"},
+		{"T5", Address{Area: AreaTM, Start: 5, Type: TypeTimer}, 2, "T5"},
+		{"Z3", Address{Area: AreaCT, Start: 3, Type: TypeCounter}, 2, "C3"},
+		{"C3:COUNTER[2]", Address{Area: AreaCT, Start: 3, Type: TypeCounter, Count: 2}, 4, "C3:COUNTER[2]"},
+	}
+	for _, i := range input {
+		a, err := ParseAddress(i.in)
+		if err != nil {
+			t.Errorf("%s: %v", i.in, err)
+			continue
+		}
+		if a != i.out || a.Size() != i.size || a.String() != i.str {
+			t.Errorf("%s: unexpected %+v of %d bytes, %s", i.in, a, a.Size(), a)
+		}
+		if b, err := ParseAddress(a.String()); err != nil || b != a {
+			t.Errorf("%s: %s does not parse back: %+v %v", i.in, a, b, err)
+		}
+	}
+
+	for _, in := range []string{"", "DB1", "DB1.DBX0", "DB1.DBX0.8", "DB1.DBW0.1", "MW2:REAL", "MD2:INT",
+		"DB1.DBX0.0:INT", "MB0:BOOL", "PE0.0", "X5", "MW2:FOO", "DB1.DBB0:INT[0]", "T5:COUNTER", "MW0:TIMER"} {
+		if a, err := ParseAddress(in); err == nil {
+			t.Errorf("%s: expected error, got %+v", in, a)
+		}
+	}
+}
+
+func TestAddressDecode(t *testing.T) {
+	input := []struct {
+		address string
+		data    []byte
+		value   interface{}
+	}{
+		{"DB1.DBX0.3", []byte{0x08}, true},
+		{"DB1.DBW0:INT", []byte{0xFF, 0xFE}, int16(-2)},
+		{"DB1.DBD0:REAL", []byte{0x3F, 0xC0, 0, 0}, float32(1.5)},
+		{"DB1.DBB0:STRING[4]", []byte{4, 2, 'h', 'i', 0, 0}, "hi"},
+		{"DB1.DBB0:CHAR[4]", []byte{'a', 'b', 0, 0}, "ab"},
+		{"C0", []byte{0x01, 0x23}, 123},
+	}
+	for _, i := range input {
+		a, err := ParseAddress(i.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := a.Decode(i.data); err != nil || v != i.value {
+			t.Errorf("%s: unexpected value %v (%T) %v", i.address, v, v, err)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	server := NewServer()
+	server.SetDB(1, []byte{0x08, 0, 0xFF, 0xFE, 0x3F, 0xC0, 0, 0, 4, 2, 'h', 'i', 0, 0})
+	server.SetArea(AreaMK, []byte{0, 1, 0, 2, 0, 3})
+	server.SetArea(AreaCT, []byte{0, 0, 0x01, 0x23})
+	_, client := newTestServerClient(t, server)
+
+	input := []struct {
+		variable string
+		value    interface{}
+	}{
+		{"DB1.DBX0.3", true},
+		{"DB1.DBW2:INT", int16(-2)},
+		{"DB1.DBD4:REAL", float32(1.5)},
+		{"DB1.DBB8:STRING[4]", "hi"},
+		{"MW0:INT[3]", []int16{1, 2, 3}},
+		{"Z1", 123},
+	}
+	for _, i := range input {
+		value, err := client.Read(i.variable, nil)
+		if err != nil || !reflect.DeepEqual(value, i.value) {
+			t.Errorf("%s: unexpected value %v (%T) %v", i.variable, value, value, err)
+		}
+	}
+	if _, err := client.Read("DB1.DBX0", nil); err == nil {
+		t.Fatal("expected error on an invalid address")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	server := NewServer()
+	server.SetDB(1, append([]byte{0xFF}, make([]byte, 31)...))
+	_, client := newTestServerClient(t, server)
+
+	// a single bit write leaves the other bits of the byte alone
+	if err := client.Write("DB1.DBX0.3", false); err != nil {
+		t.Fatal(err)
+	}
+	if db, _ := server.DB(1); db[0] != 0xF7 {
+		t.Fatalf("unexpected byte: %08b", db[0])
+	}
+	input := []struct {
+		variable string
+		value    interface{}
+		read     interface{}
+	}{
+		{"DB1.DBW2:INT", -2, int16(-2)},
+		{"DB1.DBD4:REAL", 1.5, float32(1.5)},
+		{"DB1.DBB8:STRING[6]", "hello", "hello"},
+		{"DB1.DBW16:INT[3]", []int{1, 2, 3}, []int16{1, 2, 3}},
+		{"DB1.DBD22:TIME", 1500 * time.Millisecond, 1500 * time.Millisecond},
+		{"DB1.DBX26.6:BOOL[3]", []bool{true, false, true}, []bool{true, false, true}},
+		{"DB1.DBW28:DATE", time.Date(2168, 12, 31, 0, 0, 0, 0, time.UTC), time.Date(2168, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{"DB1.DBW30:S5TIME", 9980 * time.Second, 9980 * time.Second},
+	}
+	for _, i := range input {
+		if err := client.Write(i.variable, i.value); err != nil {
+			t.Fatalf("%s: %v", i.variable, err)
+		}
+		if value, err := client.Read(i.variable, nil); err != nil || !reflect.DeepEqual(value, i.read) {
+			t.Errorf("%s: unexpected value %v (%T) %v", i.variable, value, value, err)
+		}
+	}
+	for _, i := range []struct {
+		variable string
+		value    interface{}
+	}{
+		{"DB1.DBB0", 300},
+		{"DB1.DBW2:INT", "1"},
+		{"DB1.DBB8:STRING[2]", "hello"},
+		{"DB1.DBW16:INT[3]", []int{1, 2}},
+		{"DB1.DBW28:DATE", time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{"DB1.DBW28:DATE", time.Date(2169, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"DB1.DBW30:S5TIME", 9990 * time.Second},
+	} {
+		if err := client.Write(i.variable, i.value); err == nil {
+			t.Errorf("%s: expected error writing %v", i.variable, i.value)
+		}
+	}
+}
+
+func TestWriteBoolArray(t *testing.T) {
+	server := NewServer()
+	server.SetDB(1, []byte{0xFF, 0})
+	handler, _ := newTestServerClient(t, server)
+	var writes []Operation
+	count := func(ctx context.Context, request *ProtocolDataUnit, next Invoker) (*ProtocolDataUnit, error) {
+		op, _ := OperationFromContext(ctx)
+		writes = append(writes, op)
+		return next(ctx, request)
+	}
+	client := NewClient(handler, count)
+	// the bits are written as the items of one multi write
+	if err := client.Write("DB1.DBX0.6:BOOL[4]", []bool{false, true, false, true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 1 || len(writes[0].Items) != 4 {
+		t.Fatalf("unexpected writes: %+v", writes)
+	}
+	if db, _ := server.DB(1); db[0] != 0xBF || db[1] != 0x02 {
+		t.Fatalf("unexpected bytes: %08b", db)
+	}
+}
@@ -4,6 +4,7 @@ package gos7
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 import (
+	"context"
 	"time"
 )
 
@@ -51,8 +52,16 @@ type Client interface {
 	/*block*/
 	DBFill(dbnumber int, fillchar int) error
 	DBGet(dbnumber int, usrdata []byte, size int) error
-	//general read function with S7 sytax
+	//general read function with S7 syntax, see ParseAddress
 	Read(variable string, buffer []byte) (value interface{}, err error)
+	//general write function with S7 syntax, the value is encoded to the type of the address
+	Write(variable string, value interface{}) (err error)
+	//read the fields of a struct with s7 tags from a data block, see ReadStruct
+	ReadStruct(db int, v interface{}) (err error)
+	//write the fields of a struct with s7 tags into a data block
+	WriteStruct(db int, v interface{}) (err error)
+	//write the named fields of a struct only, see ChangedFields
+	WriteStructFields(db int, v interface{}, names ...string) (err error)
 	//Get block  infor in AG area, refer an S7BlockInfor pointer
 	GetAgBlockInfo(blocktype int, blocknum int) (info S7BlockInfo, err error)
 	/***************end API AG***************/
@@ -91,4 +100,57 @@ type Client interface {
 	//write clock to PLC with datetime input
 	PGClockWrite() (dt time.Time, err error)
 	/***************end API AG***************/
+
+	ClientContext
+}
+
+// ClientContext is the context-aware variant of the Client API. A cancelled or
+// expired ctx aborts the in-flight request, and a ctx deadline takes precedence
+// over the Timeout configured on the handler.
+type ClientContext interface {
+	AGReadDBContext(ctx context.Context, dbNumber int, start int, size int, buffer []byte) (err error)
+	AGWriteDBContext(ctx context.Context, dbNumber int, start int, size int, buffer []byte) (err error)
+	AGReadMBContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGWriteMBContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGReadEBContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGWriteEBContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGReadABContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGWriteABContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGReadTMContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGWriteTMContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGReadCTContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGWriteCTContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGReadMultiContext(ctx context.Context, dataItems []S7DataItem, itemsCount int) (err error)
+	AGWriteMultiContext(ctx context.Context, dataItems []S7DataItem, itemsCount int) (err error)
+	AGReadNCKContext(ctx context.Context, addrItem *S7NckAddrItem) (dataItem *S7NckDataItem, err error)
+	AGWriteNCKContext(ctx context.Context, addrItem *S7NckAddrItem, dataItem *S7NckDataItem) (returnCode byte, err error)
+	AGReadMultiNCKContext(ctx context.Context, addrItems *[]S7NckAddrItem) (dataItems *[]S7NckDataItem, err error)
+	AGWriteMultiNCKContext(ctx context.Context, addrItems *[]S7NckAddrItem, dataItems *[]S7NckDataItem) (returnCodes []byte, err error)
+	/*block*/
+	DBFillContext(ctx context.Context, dbnumber int, fillchar int) error
+	DBGetContext(ctx context.Context, dbnumber int, usrdata []byte, size int) error
+	ReadContext(ctx context.Context, variable string, buffer []byte) (value interface{}, err error)
+	WriteContext(ctx context.Context, variable string, value interface{}) (err error)
+	ReadStructContext(ctx context.Context, db int, v interface{}) (err error)
+	WriteStructContext(ctx context.Context, db int, v interface{}) (err error)
+	WriteStructFieldsContext(ctx context.Context, db int, v interface{}, names ...string) (err error)
+	GetAgBlockInfoContext(ctx context.Context, blocktype int, blocknum int) (info S7BlockInfo, err error)
+	/*control*/
+	PLCHotStartContext(ctx context.Context) error
+	PLCColdStartContext(ctx context.Context) error
+	PLCStopContext(ctx context.Context) error
+	PLCGetStatusContext(ctx context.Context) (status int, err error)
+	/*directory*/
+	PGListBlocksContext(ctx context.Context) (list S7BlocksList, err error)
+	/*security*/
+	SetSessionPasswordContext(ctx context.Context, password string) error
+	ClearSessionPasswordContext(ctx context.Context) error
+	GetProtectionContext(ctx context.Context) (protection S7Protection, err error)
+	/*system information*/
+	GetOrderCodeContext(ctx context.Context) (info S7OrderCode, err error)
+	GetCPUInfoContext(ctx context.Context) (info S7CpuInfo, err error)
+	GetCPInfoContext(ctx context.Context) (info S7CpInfo, err error)
+	/*datetime*/
+	PGClockReadContext(ctx context.Context, datetime time.Time) error
+	PGClockWriteContext(ctx context.Context) (dt time.Time, err error)
 }
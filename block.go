@@ -5,6 +5,7 @@ package gos7
 // of the BSD license. See the LICENSE file for details.
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"time"
@@ -31,25 +32,35 @@ type S7BlockInfo struct {
 }
 
 func (mb *client) DBFill(dbnumber int, fillChar int) (err error) {
+	return mb.DBFillContext(context.Background(), dbnumber, fillChar)
+}
+
+// DBFillContext is the context-aware variant of DBFill
+func (mb *client) DBFillContext(ctx context.Context, dbnumber int, fillChar int) (err error) {
 	// bi := S7BlockInfo{}
-	bi, err := mb.GetAgBlockInfo(blockDB, dbnumber)
+	bi, err := mb.GetAgBlockInfoContext(ctx, blockDB, dbnumber)
 	if err == nil {
 		buffer := make([]byte, bi.MC7Size)
 		for c := 0; c < bi.MC7Size; c++ {
 			buffer[c] = byte(fillChar)
 		}
-		err = mb.AGWriteDB(dbnumber, 0, bi.MC7Size, buffer)
+		err = mb.AGWriteDBContext(ctx, dbnumber, 0, bi.MC7Size, buffer)
 	}
 	return
 }
 
 func (mb *client) DBGet(dbnumber int, usrdata []byte, size int) (err error) {
+	return mb.DBGetContext(context.Background(), dbnumber, usrdata, size)
+}
+
+// DBGetContext is the context-aware variant of DBGet
+func (mb *client) DBGetContext(ctx context.Context, dbnumber int, usrdata []byte, size int) (err error) {
 	// bi := S7BlockInfo{}
-	bi, err := mb.GetAgBlockInfo(blockDB, dbnumber)
+	bi, err := mb.GetAgBlockInfoContext(ctx, blockDB, dbnumber)
 	if err == nil {
 		if dbSize := bi.MC7Size; dbSize <= len(usrdata) {
 			size = dbSize
-			err = mb.AGReadDB(dbnumber, 0, dbSize, usrdata)
+			err = mb.AGReadDBContext(ctx, dbnumber, 0, dbSize, usrdata)
 			if err == nil {
 				size = dbSize
 			}
@@ -64,6 +75,11 @@ func (mb *client) DBGet(dbnumber int, usrdata []byte, size int) (err error) {
 //This function is very useful if you need to read or write data in a DB
 //which you do not know the size in advance ( MC7Size).
 func (mb *client) GetAgBlockInfo(blocktype int, blocknum int) (info S7BlockInfo, err error) {
+	return mb.GetAgBlockInfoContext(context.Background(), blocktype, blocknum)
+}
+
+// GetAgBlockInfoContext is the context-aware variant of GetAgBlockInfo
+func (mb *client) GetAgBlockInfoContext(ctx context.Context, blocktype int, blocknum int) (info S7BlockInfo, err error) {
 	//init buffer
 	requestData := make([]byte, len(s7BlockInfoTelegram))
 	copy(requestData, s7BlockInfoTelegram)
@@ -80,7 +96,7 @@ func (mb *client) GetAgBlockInfo(blocktype int, blocknum int) (info S7BlockInfo,
 	requestData[35] = byte((blocknum / 1) + 0x30)
 	request := NewProtocolDataUnit(requestData)
 	//send
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err == nil {
 		if length := len(response.Data); length > 32 {
 			if result := binary.BigEndian.Uint16(response.Data[27:]); result == 0 {
@@ -5,10 +5,13 @@ package gos7
 // of the BSD license. See the LICENSE file for details.
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"strconv"
-	"strings"
+	"sync/atomic"
+
+	"github.com/punk-one/gos7/s7comm"
 )
 
 const (
@@ -19,6 +22,7 @@ const (
 	s7areadb = 0x84 //DB
 	s7areact = 0x1C //counters
 	s7areatm = 0x1D //timers
+	s7areap  = 0x80 //peripheral I/O, direct access
 
 	// Word Length
 	s7wlbit     = 0x01 //Bit (inside a word)
@@ -57,8 +61,16 @@ const (
 	s7WriteVarFunction  = 0x05
 )
 
-//PDULength variable to store pdu length after connect
-//var tt, _ := mb.transporter.(*tcpTransporter)tt, _ := mb.transporter.(*tcpTransporter) int //global variable pdulength
+// Areas of the items read and written by clients, as passed to the Server handlers
+const (
+	AreaPE = s7areape // process inputs
+	AreaPA = s7areapa // process outputs
+	AreaMK = s7areamk // merkers
+	AreaDB = s7areadb // data blocks
+	AreaCT = s7areact // counters
+	AreaTM = s7areatm // timers
+	AreaP  = s7areap  // peripheral inputs and outputs
+)
 
 // CliePDULengthntHandler is the interface that groups the Packager and Transporter methods.
 type ClientHandler interface {
@@ -66,64 +78,123 @@ type ClientHandler interface {
 	Transporter
 }
 type client struct {
-	packager    Packager
-	transporter Transporter
+	packager     Packager
+	transporter  Transporter
+	interceptors []Interceptor
+	reference    atomic.Uint32 // PDU reference of the last request
 }
 
-// NewClient creates a new s7 client with given backend handler.
-func NewClient(handler ClientHandler) Client {
-	return &client{packager: handler, transporter: handler}
+// NewClient creates a new s7 client with given backend handler. The requests
+// pass the interceptors in order, the first one sees them first.
+func NewClient(handler ClientHandler, interceptors ...Interceptor) Client {
+	return &client{packager: handler, transporter: handler, interceptors: interceptors}
 }
 
 // NewClient2 creates a new s7 client with given backend packager and transporter.
-func NewClient2(packager Packager, transporter Transporter) Client {
-	return &client{packager: packager, transporter: transporter}
+func NewClient2(packager Packager, transporter Transporter, interceptors ...Interceptor) Client {
+	return &client{packager: packager, transporter: transporter, interceptors: interceptors}
+}
+
+// session returns the parameters negotiated by the transporter. Transporters which
+// don't report them are assumed to run a minimal session, which every S7 CPU accepts.
+func (mb *client) session() SessionParams {
+	if reporter, ok := mb.transporter.(SessionReporter); ok {
+		if params := reporter.SessionParams(); params.PDULength > 0 {
+			return params
+		}
+	}
+	return SessionParams{PDULength: minPduLength, MaxAmqCaller: 1, MaxAmqCallee: 1}
 }
 
 // implement of the interface AGReadDB
 func (mb *client) AGReadDB(dbnumber int, start int, size int, buffer []byte) (err error) {
-	return mb.readArea(s7areadb, dbnumber, start, size, s7wlbyte, buffer)
+	return mb.AGReadDBContext(context.Background(), dbnumber, start, size, buffer)
+}
+
+// implement of the interface AGReadDBContext
+func (mb *client) AGReadDBContext(ctx context.Context, dbnumber int, start int, size int, buffer []byte) (err error) {
+	return mb.readArea(ctx, s7areadb, dbnumber, start, size, s7wlbyte, buffer)
 }
 
 // implement of the interface AGWriteDB
 func (mb *client) AGWriteDB(dbNumber int, start int, size int, buffer []byte) (err error) {
-	return mb.writeArea(s7areadb, dbNumber, start, size, s7wlbyte, buffer)
+	return mb.AGWriteDBContext(context.Background(), dbNumber, start, size, buffer)
+}
+
+// implement of the interface AGWriteDBContext
+func (mb *client) AGWriteDBContext(ctx context.Context, dbNumber int, start int, size int, buffer []byte) (err error) {
+	return mb.writeArea(ctx, s7areadb, dbNumber, start, size, s7wlbyte, buffer)
 }
 
 // implement of the interface AGReadMB
 func (mb *client) AGReadMB(start int, size int, buffer []byte) (err error) {
-	return mb.readArea(s7areamk, 0, start, size, s7wlbyte, buffer)
+	return mb.AGReadMBContext(context.Background(), start, size, buffer)
+}
+
+// implement of the interface AGReadMBContext
+func (mb *client) AGReadMBContext(ctx context.Context, start int, size int, buffer []byte) (err error) {
+	return mb.readArea(ctx, s7areamk, 0, start, size, s7wlbyte, buffer)
 }
 
 // implement of the interface AGWriteMB
 func (mb *client) AGWriteMB(start int, size int, buffer []byte) (err error) {
-	return mb.writeArea(s7areamk, 0, start, size, s7wlbyte, buffer)
+	return mb.AGWriteMBContext(context.Background(), start, size, buffer)
+}
+
+// implement of the interface AGWriteMBContext
+func (mb *client) AGWriteMBContext(ctx context.Context, start int, size int, buffer []byte) (err error) {
+	return mb.writeArea(ctx, s7areamk, 0, start, size, s7wlbyte, buffer)
 }
 
 // implement of the interface AGReadEB
 func (mb *client) AGReadEB(start int, size int, buffer []byte) (err error) {
-	return mb.readArea(s7areape, 0, start, size, s7wlbyte, buffer)
+	return mb.AGReadEBContext(context.Background(), start, size, buffer)
+}
+
+// implement of the interface AGReadEBContext
+func (mb *client) AGReadEBContext(ctx context.Context, start int, size int, buffer []byte) (err error) {
+	return mb.readArea(ctx, s7areape, 0, start, size, s7wlbyte, buffer)
 }
 
 // implement of the interface AGWriteEB
 func (mb *client) AGWriteEB(start int, size int, buffer []byte) (err error) {
-	return mb.writeArea(s7areape, 0, start, size, s7wlbyte, buffer)
+	return mb.AGWriteEBContext(context.Background(), start, size, buffer)
+}
+
+// implement of the interface AGWriteEBContext
+func (mb *client) AGWriteEBContext(ctx context.Context, start int, size int, buffer []byte) (err error) {
+	return mb.writeArea(ctx, s7areape, 0, start, size, s7wlbyte, buffer)
 }
 
 // implement of the interface AGReadAB
 func (mb *client) AGReadAB(start int, size int, buffer []byte) (err error) {
-	return mb.readArea(s7areapa, 0, start, size, s7wlbyte, buffer)
+	return mb.AGReadABContext(context.Background(), start, size, buffer)
+}
+
+// implement of the interface AGReadABContext
+func (mb *client) AGReadABContext(ctx context.Context, start int, size int, buffer []byte) (err error) {
+	return mb.readArea(ctx, s7areapa, 0, start, size, s7wlbyte, buffer)
 }
 
 // implement of the interface AGWriteAB
 func (mb *client) AGWriteAB(start int, size int, buffer []byte) (err error) {
-	return mb.writeArea(s7areapa, 0, start, size, s7wlbyte, buffer)
+	return mb.AGWriteABContext(context.Background(), start, size, buffer)
+}
+
+// implement of the interface AGWriteABContext
+func (mb *client) AGWriteABContext(ctx context.Context, start int, size int, buffer []byte) (err error) {
+	return mb.writeArea(ctx, s7areapa, 0, start, size, s7wlbyte, buffer)
 }
 
 // implement of the interface AGReadTM - read timer
 func (mb *client) AGReadTM(start int, amount int, buffer []byte) (err error) {
+	return mb.AGReadTMContext(context.Background(), start, amount, buffer)
+}
+
+// implement of the interface AGReadTMContext - read timer
+func (mb *client) AGReadTMContext(ctx context.Context, start int, amount int, buffer []byte) (err error) {
 	sbuffer := make([]byte, amount*2)
-	err = mb.readArea(s7areatm, 0, start, amount, s7wltimer, sbuffer)
+	err = mb.readArea(ctx, s7areatm, 0, start, amount, s7wltimer, sbuffer)
 	if err == nil {
 		for c := 0; c < amount; c++ {
 			buffer[c] = byte(uint16(sbuffer[c*2+1])<<8 + uint16(sbuffer[c*2]))
@@ -134,19 +205,29 @@ func (mb *client) AGReadTM(start int, amount int, buffer []byte) (err error) {
 
 // implement of the interface AGWriteTM - write timer
 func (mb *client) AGWriteTM(start int, amount int, buffer []byte) (err error) {
+	return mb.AGWriteTMContext(context.Background(), start, amount, buffer)
+}
+
+// implement of the interface AGWriteTMContext - write timer
+func (mb *client) AGWriteTMContext(ctx context.Context, start int, amount int, buffer []byte) (err error) {
 	sbuffer := make([]byte, amount*2)
 	for c := 0; c < amount; c++ {
 		sbuffer[c*2+1] = byte((uint(buffer[c]) & uint(0xFF00)) >> 8)
 		sbuffer[c*2] = byte(buffer[c] & 0x00FF)
 	}
-	err = mb.writeArea(s7areatm, 0, start, amount, s7wltimer, sbuffer)
+	err = mb.writeArea(ctx, s7areatm, 0, start, amount, s7wltimer, sbuffer)
 	return err
 }
 
 // implement of the interface AGReadCT - read counter
 func (mb *client) AGReadCT(start int, amount int, buffer []byte) (err error) {
+	return mb.AGReadCTContext(context.Background(), start, amount, buffer)
+}
+
+// implement of the interface AGReadCTContext - read counter
+func (mb *client) AGReadCTContext(ctx context.Context, start int, amount int, buffer []byte) (err error) {
 	sbuffer := make([]byte, amount*2)
-	err = mb.readArea(s7areact, 0, start, amount, s7wlcounter, sbuffer)
+	err = mb.readArea(ctx, s7areact, 0, start, amount, s7wlcounter, sbuffer)
 	if err == nil {
 		for c := 0; c < amount; c++ {
 			buffer[c] = byte(uint(sbuffer[c*2+1])<<8 + uint(sbuffer[c*2]))
@@ -157,46 +238,73 @@ func (mb *client) AGReadCT(start int, amount int, buffer []byte) (err error) {
 
 // implement of the interface AGWriteCT - write counter
 func (mb *client) AGWriteCT(start int, amount int, buffer []byte) (err error) {
+	return mb.AGWriteCTContext(context.Background(), start, amount, buffer)
+}
+
+// implement of the interface AGWriteCTContext - write counter
+func (mb *client) AGWriteCTContext(ctx context.Context, start int, amount int, buffer []byte) (err error) {
 	sbuffer := make([]byte, amount*2)
 	for c := 0; c < amount; c++ {
 		sbuffer[c*2+1] = byte((uint(buffer[c]) & uint(0xFF00)) >> 8)
 		sbuffer[c*2] = byte(buffer[c] & 0x00FF)
 	}
-	err = mb.writeArea(s7areact, 0, start, amount, s7wlcounter, sbuffer)
+	err = mb.writeArea(ctx, s7areact, 0, start, amount, s7wlcounter, sbuffer)
 	return err
 }
 
 // implement of the interface AGReadNCK
 func (mb *client) AGReadNCK(addrItem *S7NckAddrItem) (dataItem *S7NckDataItem, err error) {
+	return mb.AGReadNCKContext(context.Background(), addrItem)
+}
+
+// implement of the interface AGReadNCKContext
+func (mb *client) AGReadNCKContext(ctx context.Context, addrItem *S7NckAddrItem) (dataItem *S7NckDataItem, err error) {
 	addrItems := []S7NckAddrItem{*addrItem}
 	dataItems := make([]S7NckDataItem, 0)
-	err = mb.readNckArea(&addrItems, &dataItems)
+	err = mb.readNckArea(ctx, &addrItems, &dataItems)
 	return &dataItems[0], err
 }
 
 // implement of the interface AGWriteNCK
 func (mb *client) AGWriteNCK(addrItem *S7NckAddrItem, dataItem *S7NckDataItem) (returnCode byte, err error) {
+	return mb.AGWriteNCKContext(context.Background(), addrItem, dataItem)
+}
+
+// implement of the interface AGWriteNCKContext
+func (mb *client) AGWriteNCKContext(ctx context.Context, addrItem *S7NckAddrItem, dataItem *S7NckDataItem) (returnCode byte, err error) {
 	addrItems := []S7NckAddrItem{*addrItem}
 	dataItems := []S7NckDataItem{*dataItem}
-	returnCodes, err := mb.writeNckArea(&addrItems, &dataItems)
+	returnCodes, err := mb.writeNckArea(ctx, &addrItems, &dataItems)
 	return returnCodes[0], err
 }
 
 // implement of the interface AGReadMultiNCK
 func (mb *client) AGReadMultiNCK(addrItems *[]S7NckAddrItem) (dataItems *[]S7NckDataItem, err error) {
+	return mb.AGReadMultiNCKContext(context.Background(), addrItems)
+}
+
+// implement of the interface AGReadMultiNCKContext
+func (mb *client) AGReadMultiNCKContext(ctx context.Context, addrItems *[]S7NckAddrItem) (dataItems *[]S7NckDataItem, err error) {
 	respItems := make([]S7NckDataItem, 0)
-	err = mb.readNckArea(addrItems, &respItems)
+	err = mb.readNckArea(ctx, addrItems, &respItems)
 	return &respItems, err
 }
 
 // implement of the interface AGWriteMultiNCK
 func (mb *client) AGWriteMultiNCK(addrItems *[]S7NckAddrItem, dataItems *[]S7NckDataItem) (returnCodes []byte, err error) {
+	return mb.AGWriteMultiNCKContext(context.Background(), addrItems, dataItems)
+}
+
+// implement of the interface AGWriteMultiNCKContext
+func (mb *client) AGWriteMultiNCKContext(ctx context.Context, addrItems *[]S7NckAddrItem, dataItems *[]S7NckDataItem) (returnCodes []byte, err error) {
 
-	return mb.writeNckArea(addrItems, dataItems)
+	return mb.writeNckArea(ctx, addrItems, dataItems)
 }
 
 // read generic area, pass result into a buffer
-func (mb *client) readArea(area int, dbNumber int, start int, amount int, wordLen int, buffer []byte) (err error) {
+func (mb *client) readArea(ctx context.Context, area int, dbNumber int, start int, amount int, wordLen int, buffer []byte) (err error) {
+	ctx = withOperation(ctx, Operation{Function: FunctionReadVar,
+		Area: area, DBNumber: dbNumber, Start: start, Amount: amount, WordLen: wordLen})
 	var address, numElements, maxElements, totElements, sizeRequested int
 	offset := 0
 	wordSize := 1
@@ -223,11 +331,10 @@ func (mb *client) readArea(area int, dbNumber int, start int, amount int, wordLe
 		}
 	}
 
-	tt, _ := interface{}(mb.transporter).(*TCPClientHandler)
-
-	maxElements = (tt.PDULength - 18) / wordSize // 18 = Reply telegram header //lth note here
+	maxElements = (mb.session().PDULength - 18) / wordSize // 18 = Reply telegram header //lth note here
 	totElements = amount
-	for totElements > 0 && err == nil {
+	chunks := (totElements + max(maxElements, 1) - 1) / max(maxElements, 1)
+	for part := 1; totElements > 0 && err == nil; part++ {
 		numElements = totElements
 		if numElements > maxElements {
 			numElements = maxElements
@@ -263,22 +370,24 @@ func (mb *client) readArea(area int, dbNumber int, start int, amount int, wordLe
 		address = address >> 8
 		request.Data[28] = byte(address & 0x0FF)
 		var response *ProtocolDataUnit
-		response, sendError := mb.send(&request)
+		response, sendError := mb.send(withChunk(ctx, part, chunks), &request)
 		err = sendError
 
 		if err == nil {
-			if size := len(response.Data); size < 25 {
-				err = fmt.Errorf(ErrorText(errIsoInvalidDataSize)+"'%v'", len(response.Data))
-			} else {
-				if response.Data[21] != 0xFF {
-					err = fmt.Errorf(ErrorText(CPUError(uint(response.Data[21]))))
+			var pdu *s7comm.PDU
+			if pdu, err = decodeAckData(response, s7WriteReadFunction); err == nil {
+				if len(pdu.DataItems) != 1 {
+					err = fmt.Errorf(ErrorText(errCliInvalidPlcAnswer))
+				} else if item := pdu.DataItems[0]; item.ReturnCode != 0xFF {
+					err = fmt.Errorf(ErrorText(CPUError(uint(item.ReturnCode))))
+				} else if len(item.Data) < sizeRequested {
+					err = fmt.Errorf(ErrorText(errIsoInvalidDataSize)+"'%v'", len(item.Data))
 				} else {
 					//copy response to buffer
-					copy(buffer[offset:offset+sizeRequested], response.Data[25:25+sizeRequested])
+					copy(buffer[offset:offset+sizeRequested], item.Data)
 					offset += sizeRequested
 				}
 			}
-
 		}
 		totElements -= numElements
 		start += numElements * wordSize
@@ -293,7 +402,9 @@ func (mb *client) readArea(area int, dbNumber int, start int, amount int, wordLe
 // 4.amount: amount of the address
 // 5.wordlen: bit/byte/word/dword/real/counter/timer
 // 6.buffer: a byte array input for writing
-func (mb *client) writeArea(area int, dbnumber int, start int, amount int, wordlen int, buffer []byte) (err error) {
+func (mb *client) writeArea(ctx context.Context, area int, dbnumber int, start int, amount int, wordlen int, buffer []byte) (err error) {
+	ctx = withOperation(ctx, Operation{Function: FunctionWriteVar,
+		Area: area, DBNumber: dbnumber, Start: start, Amount: amount, WordLen: wordlen})
 	var address, numElements, maxElements, totElements, dataSize, isoSize, length int
 	offset := 0
 	wordSize := 1
@@ -321,10 +432,10 @@ func (mb *client) writeArea(area int, dbnumber int, start int, amount int, wordl
 			wordlen = s7wlbyte
 		}
 	}
-	tt, _ := interface{}(mb.transporter).(*TCPClientHandler)
-	maxElements = (tt.PDULength - 35) / wordSize // 35 = Reply telegram header
+	maxElements = (mb.session().PDULength - 35) / wordSize // 35 = Reply telegram header
 	totElements = amount
-	for totElements > 0 && err == nil {
+	chunks := (totElements + max(maxElements, 1) - 1) / max(maxElements, 1)
+	for part := 1; totElements > 0 && err == nil; part++ {
 		numElements = totElements
 		if numElements > maxElements {
 			numElements = maxElements
@@ -391,15 +502,16 @@ func (mb *client) writeArea(area int, dbnumber int, start int, amount int, wordl
 
 		//expand values into array
 		request.Data = append(request.Data[:35], append(buffer[offset:offset+dataSize], request.Data[35:]...)...)
-		response, sendError := mb.send(&request)
+		response, sendError := mb.send(withChunk(ctx, part, chunks), &request)
 		err = sendError
 		if err == nil {
-			if length = len(response.Data); length == 22 {
-				if response.Data[21] != byte(0xFF) {
-					err = fmt.Errorf(ErrorText(CPUError(uint(response.Data[21]))))
+			var pdu *s7comm.PDU
+			if pdu, err = decodeAckData(response, s7WriteVarFunction); err == nil {
+				if len(pdu.ReturnCodes) != 1 {
+					err = fmt.Errorf(ErrorText(errIsoInvalidPDU))
+				} else if pdu.ReturnCodes[0] != byte(0xFF) {
+					err = fmt.Errorf(ErrorText(CPUError(uint(pdu.ReturnCodes[0]))))
 				}
-			} else {
-				err = fmt.Errorf(ErrorText(errIsoInvalidPDU))
 			}
 
 		}
@@ -410,101 +522,87 @@ func (mb *client) writeArea(area int, dbnumber int, start int, amount int, wordl
 	return
 }
 
-// DBRead
+// Read reads the value at an address in S7 syntax, see ParseAddress. The bytes
+// are read into buffer, or into a new one if buffer is too short. A bit reads as
+// a bool, not as its byte masked to the bit.
 func (mb *client) Read(variable string, buffer []byte) (value interface{}, err error) {
-	variable = strings.ToUpper(variable)              //upper
-	variable = strings.Replace(variable, " ", "", -1) //remove spaces
+	return mb.ReadContext(context.Background(), variable, buffer)
+}
 
-	if variable == "" {
-		err = fmt.Errorf("input variable is empty, variable should be S7 syntax")
+// ReadContext is the context-aware variant of Read
+func (mb *client) ReadContext(ctx context.Context, variable string, buffer []byte) (value interface{}, err error) {
+	address, err := ParseAddress(variable)
+	if err != nil {
 		return
 	}
-	//var area, dbNumber, start, amount, wordLen int
-	switch valueArea := variable[0:2]; valueArea {
-	case "EB": //input byte
-	case "EW": //input word
-	case "ED": //Input double-word
-	case "AB": //Output byte
-	case "AW": //Output word
-	case "AD": //Output double-word
-	case "MB": //Memory byte
-	case "MW": //Memory word
-	case "MD": //Memory double-word
-	case "DB": //Data Block
-		dbArray := strings.Split(variable, ".")
-		if len(dbArray) < 2 {
-			err = fmt.Errorf("Db Area read variable should not be empty")
-			return
-		}
-		dbNo, _ := strconv.ParseInt(string(string(dbArray[0])[2:]), 10, 16)
-		dbIndex, _ := strconv.ParseInt(string(string(dbArray[1])[3:]), 10, 16)
-		dbType := string(dbArray[1])[0:3]
-
-		switch dbType {
-		case "DBB": //byte
-			err = mb.AGReadDB(int(dbNo), int(dbIndex), 1, buffer)
-			value = buffer[0]
-			return
-		case "DBW": //word
-			err = mb.AGReadDB(int(dbNo), int(dbIndex), 2, buffer)
-			value = binary.BigEndian.Uint16(buffer[0:])
-			return
-		case "DBD": //dword
-			err = mb.AGReadDB(int(dbNo), int(dbIndex), 4, buffer)
-			value = binary.BigEndian.Uint32(buffer[0:])
-			return
-		case "DBX": //bit
-			mBit, _ := strconv.ParseInt(string(string(dbArray[2])[0:]), 10, 16)
-			if mBit > 7 || mBit < 0 {
-				err = fmt.Errorf("Db read bit is invalid")
-				return
-			}
-			err = mb.AGReadDB(int(dbNo), int(dbIndex), 1, buffer)
-			mask := []byte{0x01, 0x02, 0x04, 0x08, 0x10, 0x20, 0x40, 0x80}
-			value = buffer[0] & mask[mBit]
-			return
-		default:
-			err = fmt.Errorf("error when parsing dbtype")
-			return
-		}
-	default:
-		switch otherArea := variable[0:1]; otherArea {
-		case "E":
-		case "I": //input
-		case "A":
-		case "0": //output
-		case "M": //memory
-		case "T": //timer
-			startByte, _ := strconv.ParseInt(string(variable[1:]), 10, 16)
-			err = mb.AGReadTM(int(startByte), 1, buffer)
-			if err != nil {
-				return
-			}
-			helper := Helper{}
-			helper.GetValueAt(buffer, 0, value)
-			return
-		case "Z":
-		case "C": //counter
-			startByte, _ := strconv.ParseInt(string(variable[1:]), 10, 16)
-			err = mb.AGReadCT(int(startByte), 1, buffer)
-			if err != nil {
-				return
-			}
-			helper := Helper{}
-			helper.GetValueAt(buffer, 0, value)
-			return
-		default:
-			err = fmt.Errorf("error when parsing db area")
-			return
+	size := address.Size()
+	if len(buffer) < size {
+		buffer = make([]byte, size)
+	}
+	item := address.DataItem(buffer[:size])
+	if err = mb.readArea(ctx, item.Area, item.DBNumber, item.Start, item.Amount, item.WordLen, item.Data); err != nil {
+		return
+	}
+	return address.Decode(item.Data)
+}
+
+// Write encodes value to the type of an address in S7 syntax and writes it, see
+// ParseAddress. A BOOL is written as a single bit, so the other bits of its
+// byte are left alone, the bits of an array as the items of multi writes.
+func (mb *client) Write(variable string, value interface{}) error {
+	return mb.WriteContext(context.Background(), variable, value)
+}
+
+// WriteContext is the context-aware variant of Write
+func (mb *client) WriteContext(ctx context.Context, variable string, value interface{}) error {
+	address, err := ParseAddress(variable)
+	if err != nil {
+		return err
+	}
+	data, err := address.Encode(value)
+	if err != nil {
+		return err
+	}
+	if address.Type == TypeBool {
+		if address.Count > 0 {
+			batch := TagBatch{writes: []batchWrite{{address, value}}}
+			return batch.Write(ctx, mb)
 		}
+		return mb.writeArea(ctx, address.Area, address.DBNumber, address.Start*8+address.Bit, 1, s7wlbit, data)
+	}
+	item := address.DataItem(data)
+	return mb.writeArea(ctx, item.Area, item.DBNumber, item.Start, item.Amount, item.WordLen, data)
+}
 
+// send the package of a pdu request and a pdu response through the interceptors
+func (mb *client) send(ctx context.Context, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+	if len(mb.interceptors) > 0 {
+		return mb.intercept(ctx, request)
+	}
+	return mb.invoke(ctx, request)
+}
+
+// nextReference returns the PDU reference of the next request, never 0.
+func (mb *client) nextReference() uint16 {
+	for {
+		if ref := uint16(mb.reference.Add(1)); ref != 0 {
+			return ref
+		}
 	}
-	return
 }
 
-// send the package of a pdu request and a pdu response, check for response error and verify the package
-func (mb *client) send(request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
-	dataResponse, err := mb.transporter.Send(request.Data)
+// invoke sends the request to the transporter, check for response error and verify the package
+func (mb *client) invoke(ctx context.Context, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+	var dataResponse []byte
+	if isS7PDU(request.Data) {
+		// a unique reference tells a stale response from the answer
+		binary.BigEndian.PutUint16(request.Data[11:], mb.nextReference())
+	}
+	if tr, ok := mb.transporter.(ContextTransporter); ok {
+		dataResponse, err = tr.SendContext(ctx, request.Data)
+	} else if err = ctx.Err(); err == nil {
+		dataResponse, err = mb.transporter.Send(request.Data)
+	}
 	if err != nil {
 		return
 	}
@@ -525,6 +623,35 @@ func (mb *client) send(request *ProtocolDataUnit) (response *ProtocolDataUnit, e
 	return response, err
 }
 
+// decodeAckData decodes the response to a job with the function, the error in its
+// header is returned as CPU error.
+func decodeAckData(response *ProtocolDataUnit, function byte) (*s7comm.PDU, error) {
+	frame, err := s7comm.Decode(response.Data)
+	return checkAckData(frame, err, function)
+}
+
+// decodeAckHeader is decodeAckData without decoding the parameters and data,
+// for NCK reads.
+func decodeAckHeader(response *ProtocolDataUnit, function byte) (*s7comm.PDU, error) {
+	frame, err := s7comm.DecodeHeader(response.Data)
+	return checkAckData(frame, err, function)
+}
+
+// checkAckData returns the PDU of the decoded response frame to a job with the
+// function, or the error of the response.
+func checkAckData(frame *s7comm.Frame, err error, function byte) (*s7comm.PDU, error) {
+	if err != nil || frame.PDU == nil || (frame.PDU.ROSCTR != s7comm.AckData && frame.PDU.ROSCTR != s7comm.Ack) {
+		return nil, fmt.Errorf(ErrorText(errIsoInvalidPDU))
+	}
+	if code := frame.PDU.Error(); code != 0 {
+		return nil, fmt.Errorf(ErrorText(CPUError(uint(code))))
+	}
+	if frame.PDU.Function != function {
+		return nil, fmt.Errorf(ErrorText(errCliInvalidPlcAnswer))
+	}
+	return frame.PDU, nil
+}
+
 // responseError get response error from pdu return S7Error with high and low byte
 func responseError(response *ProtocolDataUnit) error {
 	s7Error := &S7Error{}
@@ -577,7 +704,8 @@ func dataSizeByte(wordLength int) int {
 }
 
 // read generic area, pass result into a buffer
-func (mb *client) readNckArea(addrItem *[]S7NckAddrItem, respItems *[]S7NckDataItem) (err error) {
+func (mb *client) readNckArea(ctx context.Context, addrItem *[]S7NckAddrItem, respItems *[]S7NckDataItem) (err error) {
+	ctx = withOperation(ctx, Operation{Function: FunctionNCKRead, NckItems: *addrItem})
 	addrCnt := len(*addrItem)
 	if addrCnt == 0 {
 		return fmt.Errorf("read NckArea Must Give DataItem")
@@ -606,19 +734,15 @@ func (mb *client) readNckArea(addrItem *[]S7NckAddrItem, respItems *[]S7NckDataI
 		request.Data[offset+9] = 1 // LINE COUNT
 	}
 	var response *ProtocolDataUnit
-	response, sendError := mb.send(&request)
+	response, sendError := mb.send(ctx, &request)
 	err = sendError
 
 	if err == nil {
-		if size := len(response.Data); size < 25 {
-			err = fmt.Errorf(ErrorText(errIsoInvalidDataSize)+"'%v'", len(response.Data))
-		} else {
-			fmt.Printf("response data: %v\n", response.Data)
+		var pdu *s7comm.PDU
+		// NCK items have no fill bytes and their length in bytes
+		if pdu, err = decodeAckHeader(response, s7WriteReadFunction); err == nil {
 			//copy response to buffer
-			err := ParseS7NckRespItems(response.Data[21:], respItems)
-			if err != nil {
-				fmt.Println(err)
-			}
+			err = ParseS7NckRespItems(pdu.Data, respItems)
 		}
 	}
 	return
@@ -707,7 +831,8 @@ func combineToByte(area int, unit int) byte {
 // 4.amount: amount of the address
 // 5.wordlen: bit/byte/word/dword/real/counter/timer
 // 6.buffer: a byte array input for writing
-func (mb *client) writeNckArea(addrItems *[]S7NckAddrItem, dataItems *[]S7NckDataItem) (returnCodes []byte, err error) {
+func (mb *client) writeNckArea(ctx context.Context, addrItems *[]S7NckAddrItem, dataItems *[]S7NckDataItem) (returnCodes []byte, err error) {
+	ctx = withOperation(ctx, Operation{Function: FunctionNCKWrite, NckItems: *addrItems})
 	// Setup the telegram
 	addrCnt := len(*addrItems)
 	dataCnt := len(*dataItems)
@@ -748,11 +873,15 @@ func (mb *client) writeNckArea(addrItems *[]S7NckAddrItem, dataItems *[]S7NckDat
 	//expand values into array
 	addrOffset := 19 + addrCnt*10
 	request.Data = append(request.Data[:addrOffset], dataBuff[:]...)
-	response, sendError := mb.send(&request)
+	response, sendError := mb.send(ctx, &request)
 	err = sendError
 	if err == nil {
-		if length := len(response.Data); length == 21+addrCnt {
-			return response.Data[21:], nil
+		var pdu *s7comm.PDU
+		if pdu, err = decodeAckData(response, s7WriteVarFunction); err != nil {
+			return
+		}
+		if len(pdu.ReturnCodes) == addrCnt {
+			return pdu.ReturnCodes, nil
 		} else {
 			err = fmt.Errorf(ErrorText(errIsoInvalidPDU))
 		}
@@ -4,17 +4,23 @@ package gos7
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 )
 
 // implement PLC hot start interface
 func (mb *client) PLCHotStart() error {
+	return mb.PLCHotStartContext(context.Background())
+}
+
+// PLCHotStartContext is the context-aware variant of PLCHotStart
+func (mb *client) PLCHotStartContext(ctx context.Context) error {
 	requestData := make([]byte, len(s7HotStartTelegram))
 	copy(requestData, s7HotStartTelegram)
 	request := NewProtocolDataUnit(requestData)
 	//send
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err == nil {
 		if length := len(response.Data); length >= 20 { // 20 is the minimum expected
 			if int(response.Data[19]) != pduStart {
@@ -35,11 +41,16 @@ func (mb *client) PLCHotStart() error {
 
 // implement of PLC Colde Start interface
 func (mb *client) PLCColdStart() error {
+	return mb.PLCColdStartContext(context.Background())
+}
+
+// PLCColdStartContext is the context-aware variant of PLCColdStart
+func (mb *client) PLCColdStartContext(ctx context.Context) error {
 	requestData := make([]byte, len(s7ColdStartTelegram))
 	copy(requestData, s7ColdStartTelegram)
 	request := NewProtocolDataUnit(requestData)
 	//send
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err == nil {
 		if length := len(response.Data); length >= 20 { // 20 is the minimum expected
 			if int(response.Data[19]) != pduStart {
@@ -58,12 +69,17 @@ func (mb *client) PLCColdStart() error {
 	return err
 }
 func (mb *client) PLCStop() error {
+	return mb.PLCStopContext(context.Background())
+}
+
+// PLCStopContext is the context-aware variant of PLCStop
+func (mb *client) PLCStopContext(ctx context.Context) error {
 	requestData := make([]byte, len(s7StopTelegram))
 	copy(requestData, s7StopTelegram)
 
 	request := NewProtocolDataUnit(requestData)
 	//send
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err == nil {
 		if length := len(response.Data); length >= 20 { // 20 is the minimum expected
 			if int(response.Data[19]) != pduStop {
@@ -83,13 +99,18 @@ func (mb *client) PLCStop() error {
 }
 
 func (mb *client) PLCGetStatus() (status int, err error) {
+	return mb.PLCGetStatusContext(context.Background())
+}
+
+// PLCGetStatusContext is the context-aware variant of PLCGetStatus
+func (mb *client) PLCGetStatusContext(ctx context.Context) (status int, err error) {
 	//initialize
 	requestData := make([]byte, len(s7GetStatusTelegram))
 	copy(requestData, s7GetStatusTelegram)
 
 	request := NewProtocolDataUnit(requestData)
 	//send
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err == nil {
 		if length := len(response.Data); length > 30 { // 30 is the minimum expected
 			if result := binary.BigEndian.Uint16(response.Data[27:]); result == 0 {
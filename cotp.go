@@ -0,0 +1,210 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// ISO 8073 class 0 TPDU codes
+const (
+	cotpCR = 0xE0 // Connection Request
+	cotpCC = 0xD0 // Connection Confirm
+	cotpDR = 0x80 // Disconnect Request
+	cotpER = 0x70 // Error
+	cotpDT = 0xF0 // Data
+
+	cotpEOT          = 0x80 // last DT TPDU of a PDU
+	cotpTPDUSizeCode = 0xC0 // TPDU size parameter, the value is the size as a power of 2
+	cotpDTHSize      = 3    // LI, DT code, TPDU-NR/EOT
+	tpktHSize        = 4
+)
+
+// DisconnectError is returned when the peer sends a DR (Disconnect Request) TPDU,
+// e.g. to refuse a connection request with unknown TSAPs.
+type DisconnectError struct {
+	Reason byte
+}
+
+func (e *DisconnectError) Error() string {
+	return fmt.Sprintf("ISO : Disconnect request received: %s (0x%02X)", disconnectReasonText(e.Reason), e.Reason)
+}
+
+func disconnectReasonText(reason byte) string {
+	switch reason {
+	case 0:
+		return "reason not specified"
+	case 1:
+		return "congestion at TSAP"
+	case 2:
+		return "session entity not attached to TSAP"
+	case 3:
+		return "address unknown"
+	case 128:
+		return "normal disconnect initiated by the session entity"
+	case 129:
+		return "remote transport entity congestion at connect request time"
+	case 130:
+		return "connection negotiation failed"
+	case 131:
+		return "duplicate source reference"
+	case 132:
+		return "mismatched references"
+	case 133:
+		return "protocol error"
+	case 135:
+		return "reference overflow"
+	case 136:
+		return "connection request refused on this network connection"
+	case 138:
+		return "header or parameter length invalid"
+	default:
+		return "unknown reason"
+	}
+}
+
+// TPDUError is returned when the peer rejects a TPDU with an ER (Error) TPDU.
+type TPDUError struct {
+	Cause byte
+}
+
+func (e *TPDUError) Error() string {
+	return fmt.Sprintf("ISO : Error TPDU received: %s (0x%02X)", rejectCauseText(e.Cause), e.Cause)
+}
+
+func rejectCauseText(cause byte) string {
+	switch cause {
+	case 0:
+		return "reason not specified"
+	case 1:
+		return "invalid parameter code"
+	case 2:
+		return "invalid TPDU type"
+	case 3:
+		return "invalid parameter value"
+	default:
+		return "unknown cause"
+	}
+}
+
+// receive reads TPDUs from conn. DT TPDUs are reassembled until the one carrying
+// EOT and returned as a single TPKT/COTP frame holding the complete S7 PDU, empty
+// DT TPDUs are skipped. DR and ER TPDUs are returned as errors, any other TPDU
+// (e.g. CC) is returned as it was received. A TPDU larger than tpduSize is
+// rejected, 0 means the TPDU size is not negotiated yet.
+func receive(conn net.Conn, maxLength, tpduSize int) (response []byte, err error) {
+	response = make([]byte, isoHSize)
+	for {
+		var frame []byte
+		if frame, err = readTPKT(conn, maxLength+isoHSize); err != nil {
+			return nil, err
+		}
+		tpdu := frame[tpktHSize:]
+		if len(tpdu) < 2 || int(tpdu[0]) >= len(tpdu) {
+			return nil, fmt.Errorf(ErrorText(errIsoInvalidPDU))
+		}
+		if tpduSize > 0 && len(tpdu) > tpduSize {
+			return nil, fmt.Errorf("ISO : TPDU of %d bytes exceeds the negotiated size of %d", len(tpdu), tpduSize)
+		}
+		switch tpdu[1] & 0xF0 {
+		case cotpDT:
+			if tpdu[0] < cotpDTHSize-1 {
+				return nil, fmt.Errorf(ErrorText(errIsoInvalidPDU))
+			}
+			response = append(response, tpdu[1+tpdu[0]:]...)
+			if len(response) > maxLength+isoHSize {
+				return nil, fmt.Errorf(ErrorText(errIsoInvalidPDU))
+			}
+			if tpdu[2]&cotpEOT == 0 || len(response) == isoHSize {
+				// more data follows, or an empty keep alive
+				continue
+			}
+			if len(response) < minPduSize {
+				return nil, fmt.Errorf(ErrorText(errIsoInvalidPDU))
+			}
+			copy(response, tpktISOTelegram)
+			binary.BigEndian.PutUint16(response[2:], uint16(len(response)))
+			return response, nil
+		case cotpDR:
+			if len(tpdu) < 7 {
+				return nil, fmt.Errorf(ErrorText(errIsoInvalidPDU))
+			}
+			return nil, &DisconnectError{Reason: tpdu[6]}
+		case cotpER:
+			if len(tpdu) < 5 {
+				return nil, fmt.Errorf(ErrorText(errIsoInvalidPDU))
+			}
+			return nil, &TPDUError{Cause: tpdu[4]}
+		default:
+			if len(response) > isoHSize {
+				// the PDU was interrupted
+				return nil, fmt.Errorf(ErrorText(errIsoInvalidPDU))
+			}
+			return frame, nil
+		}
+	}
+}
+
+// readTPKT reads one TPKT (RFC 1006) frame of at most maxLength bytes.
+func readTPKT(conn net.Conn, maxLength int) (frame []byte, err error) {
+	header := make([]byte, tpktHSize)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if length < tpktHSize+2 || length > maxLength {
+		return nil, fmt.Errorf(ErrorText(errIsoInvalidPDU))
+	}
+	frame = make([]byte, length)
+	copy(frame, header)
+	if _, err = io.ReadFull(conn, frame[tpktHSize:]); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// write sends a TPKT framed request. A DT TPDU larger than tpduSize is split
+// into several DT TPDUs, only the last one carries EOT.
+func write(conn net.Conn, request []byte, tpduSize int) (err error) {
+	if tpduSize <= cotpDTHSize || len(request) <= isoHSize || request[5] != cotpDT || len(request)-tpktHSize <= tpduSize {
+		_, err = conn.Write(request)
+		return
+	}
+	payload := request[isoHSize:]
+	chunk := tpduSize - cotpDTHSize
+	frames := make([]byte, 0, len(request)+(len(payload)/chunk+1)*isoHSize)
+	for len(payload) > 0 {
+		n := min(chunk, len(payload))
+		var eot byte
+		if n == len(payload) {
+			eot = cotpEOT
+		}
+		frames = append(frames, 3, 0, byte((n+isoHSize)>>8), byte(n+isoHSize), cotpDTHSize-1, cotpDT, eot)
+		frames = append(frames, payload[:n]...)
+		payload = payload[n:]
+	}
+	_, err = conn.Write(frames)
+	return
+}
+
+// tpduSizeParam returns the TPDU size proposed in a CR or CC TPDU, 0 if it is absent.
+func tpduSizeParam(frame []byte) int {
+	// LI, code, dst ref, src ref, class, then the variable part
+	i := tpktHSize + 7
+	if len(frame) <= tpktHSize {
+		return 0
+	}
+	end := min(len(frame), tpktHSize+1+int(frame[tpktHSize]))
+	for i+2 <= end {
+		code, length := frame[i], int(frame[i+1])
+		if code == cotpTPDUSizeCode && length == 1 && i+2 < end && frame[i+2] >= 7 && frame[i+2] <= 13 {
+			return 1 << frame[i+2]
+		}
+		i += 2 + length
+	}
+	return 0
+}
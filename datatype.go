@@ -0,0 +1,507 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// DataType is the S7 data type of the value at an Address.
+type DataType int
+
+// Data types, with the Go type of their values
+const (
+	TypeBool      DataType = iota + 1 // bool
+	TypeByte                          // byte
+	TypeChar                          // string of one character, an array a string of Count
+	TypeSInt                          // int8
+	TypeUSInt                         // uint8
+	TypeWord                          // uint16
+	TypeInt                           // int16
+	TypeUInt                          // uint16
+	TypeDWord                         // uint32
+	TypeDInt                          // int32
+	TypeUDInt                         // uint32
+	TypeLWord                         // uint64
+	TypeLInt                          // int64
+	TypeULInt                         // uint64
+	TypeReal                          // float32
+	TypeLReal                         // float64
+	TypeString                        // string
+	TypeWString                       // string
+	TypeTime                          // time.Duration, in ms
+	TypeS5Time                        // time.Duration
+	TypeDate                          // time.Time
+	TypeTimeOfDay                     // time.Duration since midnight, in ms
+	TypeDateTime                      // time.Time, DATE_AND_TIME
+	TypeDTL                           // time.Time
+	TypeTimer                         // time.Duration, the S5TIME of a timer
+	TypeCounter                       // int, the BCD value of a counter
+	TypeLTime                         // time.Duration, in ns
+)
+
+// dataTypes are the names and sizes in bytes of the data types. STRING and
+// WSTRING have their header size, the characters add to it.
+var dataTypes = map[DataType]struct {
+	name string
+	size int
+}{
+	TypeBool:      {"BOOL", 1},
+	TypeByte:      {"BYTE", 1},
+	TypeChar:      {"CHAR", 1},
+	TypeSInt:      {"SINT", 1},
+	TypeUSInt:     {"USINT", 1},
+	TypeWord:      {"WORD", 2},
+	TypeInt:       {"INT", 2},
+	TypeUInt:      {"UINT", 2},
+	TypeDWord:     {"DWORD", 4},
+	TypeDInt:      {"DINT", 4},
+	TypeUDInt:     {"UDINT", 4},
+	TypeLWord:     {"LWORD", 8},
+	TypeLInt:      {"LINT", 8},
+	TypeULInt:     {"ULINT", 8},
+	TypeReal:      {"REAL", 4},
+	TypeLReal:     {"LREAL", 8},
+	TypeString:    {"STRING", 2},
+	TypeWString:   {"WSTRING", 4},
+	TypeTime:      {"TIME", 4},
+	TypeLTime:     {"LTIME", 8},
+	TypeS5Time:    {"S5TIME", 2},
+	TypeDate:      {"DATE", 2},
+	TypeTimeOfDay: {"TIME_OF_DAY", 4},
+	TypeDateTime:  {"DATE_AND_TIME", 8},
+	TypeDTL:       {"DTL", 12},
+	TypeTimer:     {"TIMER", 2},
+	TypeCounter:   {"COUNTER", 2},
+}
+
+// dataTypeAliases are the short names accepted besides the names of the types.
+var dataTypeAliases = map[string]DataType{
+	"TOD": TypeTimeOfDay,
+	"DT":  TypeDateTime,
+	"T":   TypeTimer,
+	"C":   TypeCounter,
+	"Z":   TypeCounter,
+}
+
+// maxStringLength is the length of a STRING or WSTRING declared without one.
+const maxStringLength = 254
+
+func (t DataType) String() string {
+	if info, ok := dataTypes[t]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("DataType(%d)", int(t))
+}
+
+// Size returns the size in bytes of a value of the type, strings of length
+// characters. The bits of BOOL have 1 byte.
+func (t DataType) Size(length int) int {
+	switch t {
+	case TypeString:
+		return 2 + length
+	case TypeWString:
+		return 4 + 2*length
+	}
+	return dataTypes[t].size
+}
+
+// parseDataType returns the type with the name or alias.
+func parseDataType(name string) (DataType, bool) {
+	if t, ok := dataTypeAliases[name]; ok {
+		return t, true
+	}
+	for t, info := range dataTypes {
+		if info.name == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// decodeValue decodes a value of type t of length characters from the start of b.
+func decodeValue(t DataType, length int, b []byte) (interface{}, error) {
+	if size := t.Size(length); len(b) < size {
+		return nil, fmt.Errorf("s7: %d bytes are too short for %v", len(b), t)
+	}
+	helper := Helper{}
+	switch t {
+	case TypeBool:
+		return b[0]&1 != 0, nil
+	case TypeByte, TypeUSInt:
+		return b[0], nil
+	case TypeChar:
+		return string(b[:1]), nil
+	case TypeSInt:
+		return int8(b[0]), nil
+	case TypeWord, TypeUInt:
+		return binary.BigEndian.Uint16(b), nil
+	case TypeInt:
+		return int16(binary.BigEndian.Uint16(b)), nil
+	case TypeDWord, TypeUDInt:
+		return binary.BigEndian.Uint32(b), nil
+	case TypeDInt:
+		return int32(binary.BigEndian.Uint32(b)), nil
+	case TypeLWord, TypeULInt:
+		return binary.BigEndian.Uint64(b), nil
+	case TypeLInt:
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case TypeReal:
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), nil
+	case TypeLReal:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case TypeString:
+		n := min(int(b[1]), int(b[0]), length)
+		return string(b[2 : 2+n]), nil
+	case TypeWString:
+		n := min(int(binary.BigEndian.Uint16(b[2:])), int(binary.BigEndian.Uint16(b)), length)
+		chars := make([]uint16, n)
+		for i := range chars {
+			chars[i] = binary.BigEndian.Uint16(b[4+2*i:])
+		}
+		return string(utf16.Decode(chars)), nil
+	case TypeTime, TypeTimeOfDay:
+		return time.Duration(int32(binary.BigEndian.Uint32(b))) * time.Millisecond, nil
+	case TypeLTime:
+		return time.Duration(binary.BigEndian.Uint64(b)), nil
+	case TypeS5Time, TypeTimer:
+		return helper.GetS5TimeAt(b, 0), nil
+	case TypeDate:
+		return time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, int(binary.BigEndian.Uint16(b))), nil
+	case TypeDateTime:
+		return helper.GetDateTimeAt(b, 0), nil
+	case TypeDTL:
+		return helper.GetDTLAt(b, 0), nil
+	case TypeCounter:
+		return decodeBcd(b[0]&0x0F)*100 + decodeBcd(b[1]), nil
+	}
+	return nil, fmt.Errorf("s7: unknown data type %v", t)
+}
+
+// decodeValues decodes count values of type t, the values of BOOL are count
+// bits from bit on. An array of CHAR is decoded to a string.
+func decodeValues(t DataType, length, count, bit int, b []byte) (interface{}, error) {
+	switch t {
+	case TypeBool:
+		if len(b)*8 < bit+count {
+			return nil, fmt.Errorf("s7: %d bytes are too short for %d bits", len(b), count)
+		}
+		values := make([]bool, count)
+		for i := range values {
+			n := bit + i
+			values[i] = b[n/8]&(1<<(n%8)) != 0
+		}
+		return values, nil
+	case TypeChar:
+		if len(b) < count {
+			return nil, fmt.Errorf("s7: %d bytes are too short for %d characters", len(b), count)
+		}
+		return strings.TrimRight(string(b[:count]), "\x00"), nil
+	}
+	size := t.Size(length)
+	var values []interface{}
+	for i := 0; i < count; i++ {
+		v, err := decodeValue(t, length, b[min(i*size, len(b)):])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return typedSlice(t, values), nil
+}
+
+// typedSlice returns the values as a slice of the Go type of t, e.g. []int16 for INT.
+func typedSlice(t DataType, values []interface{}) interface{} {
+	switch t {
+	case TypeByte, TypeUSInt:
+		return convertSlice[byte](values)
+	case TypeSInt:
+		return convertSlice[int8](values)
+	case TypeWord, TypeUInt:
+		return convertSlice[uint16](values)
+	case TypeInt:
+		return convertSlice[int16](values)
+	case TypeDWord, TypeUDInt:
+		return convertSlice[uint32](values)
+	case TypeDInt:
+		return convertSlice[int32](values)
+	case TypeLWord, TypeULInt:
+		return convertSlice[uint64](values)
+	case TypeLInt:
+		return convertSlice[int64](values)
+	case TypeReal:
+		return convertSlice[float32](values)
+	case TypeLReal:
+		return convertSlice[float64](values)
+	case TypeString, TypeWString:
+		return convertSlice[string](values)
+	case TypeTime, TypeLTime, TypeTimeOfDay, TypeS5Time, TypeTimer:
+		return convertSlice[time.Duration](values)
+	case TypeDate, TypeDateTime, TypeDTL:
+		return convertSlice[time.Time](values)
+	case TypeCounter:
+		return convertSlice[int](values)
+	}
+	return values
+}
+
+func convertSlice[T any](values []interface{}) []T {
+	s := make([]T, len(values))
+	for i, v := range values {
+		s[i] = v.(T)
+	}
+	return s
+}
+
+// encodeValue encodes value to type t of length characters at the start of b,
+// which has the size of the type.
+func encodeValue(t DataType, length int, value interface{}, b []byte) error {
+	helper := Helper{}
+	mismatch := func() error {
+		return fmt.Errorf("s7: cannot encode %T to %v", value, t)
+	}
+	outOfRange := func() error {
+		return fmt.Errorf("s7: %v is out of the range of %v", value, t)
+	}
+	switch t {
+	case TypeBool:
+		v, ok := value.(bool)
+		if !ok {
+			return mismatch()
+		}
+		b[0] = 0
+		if v {
+			b[0] = 1
+		}
+	case TypeByte, TypeUSInt, TypeSInt, TypeWord, TypeUInt, TypeInt, TypeDWord, TypeUDInt, TypeDInt, TypeLInt:
+		v, ok := toInt64(value)
+		if !ok {
+			return mismatch()
+		}
+		lo, hi := integerRange(t)
+		if v < lo || v > hi {
+			return outOfRange()
+		}
+		switch t.Size(0) {
+		case 1:
+			b[0] = byte(v)
+		case 2:
+			helper.SetValueAt(b, 0, uint16(v))
+		case 4:
+			helper.SetValueAt(b, 0, uint32(v))
+		case 8:
+			helper.SetValueAt(b, 0, v)
+		}
+	case TypeLWord, TypeULInt:
+		v, ok := toUint64(value)
+		if !ok {
+			return mismatch()
+		}
+		helper.SetValueAt(b, 0, v)
+	case TypeReal, TypeLReal:
+		v, ok := toFloat64(value)
+		if !ok {
+			return mismatch()
+		}
+		if t == TypeReal {
+			if math.Abs(v) > math.MaxFloat32 && !math.IsInf(v, 0) {
+				return outOfRange()
+			}
+			helper.SetRealAt(b, 0, float32(v))
+		} else {
+			helper.SetLRealAt(b, 0, v)
+		}
+	case TypeChar:
+		v, ok := value.(string)
+		if !ok || len(v) != 1 {
+			return mismatch()
+		}
+		b[0] = v[0]
+	case TypeString:
+		v, ok := value.(string)
+		if !ok {
+			return mismatch()
+		}
+		if len(v) > length {
+			return fmt.Errorf("s7: %q is longer than %v[%d]", v, t, length)
+		}
+		helper.SetStringAt(b, 0, length, v)
+	case TypeWString:
+		v, ok := value.(string)
+		if !ok {
+			return mismatch()
+		}
+		chars := utf16.Encode([]rune(v))
+		if len(chars) > length {
+			return fmt.Errorf("s7: %q is longer than %v[%d]", v, t, length)
+		}
+		binary.BigEndian.PutUint16(b, uint16(length))
+		binary.BigEndian.PutUint16(b[2:], uint16(len(chars)))
+		for i, c := range chars {
+			binary.BigEndian.PutUint16(b[4+2*i:], c)
+		}
+	case TypeTime, TypeTimeOfDay:
+		var ms int64
+		switch v := value.(type) {
+		case time.Duration:
+			ms = v.Milliseconds()
+		case time.Time:
+			if t != TypeTimeOfDay {
+				return mismatch()
+			}
+			ms = int64(v.Hour()*3600000 + v.Minute()*60000 + v.Second()*1000 + v.Nanosecond()/1000000)
+		default:
+			return mismatch()
+		}
+		if ms < math.MinInt32 || ms > math.MaxInt32 || t == TypeTimeOfDay && (ms < 0 || ms >= 24*3600000) {
+			return outOfRange()
+		}
+		helper.SetValueAt(b, 0, int32(ms))
+	case TypeLTime:
+		v, ok := value.(time.Duration)
+		if !ok {
+			return mismatch()
+		}
+		helper.SetValueAt(b, 0, int64(v))
+	case TypeS5Time, TypeTimer:
+		v, ok := value.(time.Duration)
+		if !ok {
+			return mismatch()
+		}
+		if v < 0 || v >= 9990*time.Second {
+			return outOfRange()
+		}
+		helper.SetS5TimeAt(b, 0, v)
+	case TypeDate, TypeDateTime, TypeDTL:
+		v, ok := value.(time.Time)
+		if !ok {
+			return mismatch()
+		}
+		switch t {
+		case TypeDate:
+			if v.Year() < 1990 || v.Year() > 2168 {
+				return outOfRange()
+			}
+			// the days since 1990 are unsigned, up to 2168
+			binary.BigEndian.PutUint16(b, uint16(v.Sub(time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC))/(24*time.Hour)))
+		case TypeDateTime:
+			if v.Year() < 1990 || v.Year() > 2089 {
+				return outOfRange()
+			}
+			helper.SetDateTimeAt(b, 0, v)
+		case TypeDTL:
+			helper.SetDTLAt(b, 0, v)
+		}
+	case TypeCounter:
+		v, ok := toInt64(value)
+		if !ok {
+			return mismatch()
+		}
+		if v < 0 || v > 999 {
+			return outOfRange()
+		}
+		b[0], b[1] = encodeBcd(int(v/100)), encodeBcd(int(v%100))
+	default:
+		return fmt.Errorf("s7: unknown data type %v", t)
+	}
+	return nil
+}
+
+// encodeValues encodes the count elements of the slice value to type t. An
+// array of CHAR takes a string, which is padded with zeros.
+func encodeValues(t DataType, length, count int, value interface{}, b []byte) error {
+	if s, ok := value.(string); ok && t == TypeChar {
+		if len(s) > count {
+			return fmt.Errorf("s7: %q is longer than %v[%d]", s, t, count)
+		}
+		clear(b[:count])
+		copy(b, s)
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return fmt.Errorf("s7: cannot encode %T to an array of %v", value, t)
+	}
+	if v.Len() != count {
+		return fmt.Errorf("s7: %d values for an array of %d %v", v.Len(), count, t)
+	}
+	size := t.Size(length)
+	for i := 0; i < count; i++ {
+		if err := encodeValue(t, length, v.Index(i).Interface(), b[i*size:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// integerRange returns the range of the integer type t.
+func integerRange(t DataType) (lo, hi int64) {
+	switch t {
+	case TypeSInt:
+		return math.MinInt8, math.MaxInt8
+	case TypeByte, TypeUSInt:
+		return 0, math.MaxUint8
+	case TypeInt:
+		return math.MinInt16, math.MaxInt16
+	case TypeWord, TypeUInt:
+		return 0, math.MaxUint16
+	case TypeDInt:
+		return math.MinInt32, math.MaxInt32
+	case TypeDWord, TypeUDInt:
+		return 0, math.MaxUint32
+	}
+	return math.MinInt64, math.MaxInt64
+}
+
+// toInt64 converts an integer of any Go type.
+func toInt64(value interface{}) (int64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v.Uint()), true
+	}
+	return 0, false
+}
+
+// toUint64 converts a non-negative integer of any Go type.
+func toUint64(value interface{}) (uint64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() < 0 {
+			return 0, false
+		}
+		return uint64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), true
+	}
+	return 0, false
+}
+
+// toFloat64 converts a number of any Go type.
+func toFloat64(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	if i, ok := toInt64(value); ok {
+		return float64(i), true
+	}
+	if u, ok := toUint64(value); ok {
+		return float64(u), true
+	}
+	return 0, false
+}
@@ -4,6 +4,7 @@ package gos7
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"time"
@@ -11,11 +12,16 @@ import (
 
 //implement GetPLCDateTime
 func (mb *client) PGClockWrite() (datetime time.Time, err error) {
+	return mb.PGClockWriteContext(context.Background())
+}
+
+// PGClockWriteContext is the context-aware variant of PGClockWrite
+func (mb *client) PGClockWriteContext(ctx context.Context) (datetime time.Time, err error) {
 	requestData := make([]byte, len(s7GetDatetimeTelegram))
 	copy(requestData, s7GetDatetimeTelegram)
 	request := NewProtocolDataUnit(requestData)
 	//send
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if length := len(response.Data); length > 30 {
 		if (binary.BigEndian.Uint16(response.Data[27:]) == 0) && (response.Data[29] == 0xFF) {
 			var s7 Helper
@@ -32,6 +38,11 @@ func (mb *client) PGClockWrite() (datetime time.Time, err error) {
 
 //implement SetPLCDateTime
 func (mb *client) PGClockRead(datetime time.Time) (err error) {
+	return mb.PGClockReadContext(context.Background(), datetime)
+}
+
+// PGClockReadContext is the context-aware variant of PGClockRead
+func (mb *client) PGClockReadContext(ctx context.Context, datetime time.Time) (err error) {
 	requestData := make([]byte, len(s7SetDatetimeTelegram))
 	copy(requestData, s7SetDatetimeTelegram)
 	var s7 Helper
@@ -39,7 +50,7 @@ func (mb *client) PGClockRead(datetime time.Time) (err error) {
 
 	request := NewProtocolDataUnit(requestData)
 	//send
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if length := len(response.Data); length > 30 {
 		if binary.BigEndian.Uint16(response.Data[27:]) != 0 {
 			err = fmt.Errorf(ErrorText(errCliInvalidPlcAnswer))
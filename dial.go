@@ -0,0 +1,25 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"net"
+)
+
+// DialFunc opens a connection to address, like net.Dialer.DialContext. The ctx
+// deadline is set to the Timeout of the handler unless the caller gave one.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// DialLocal returns a DialFunc which binds the connection to the local IP address
+// and port, e.g. to choose the network interface on a host with several ones.
+func DialLocal(localAddr string, localPort int) DialFunc {
+	dialer := &net.Dialer{
+		LocalAddr: &net.TCPAddr{
+			IP:   net.ParseIP(localAddr),
+			Port: localPort,
+		},
+	}
+	return dialer.DialContext
+}
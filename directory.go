@@ -1,6 +1,7 @@
 package gos7
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -31,20 +32,22 @@ type S7BlocksList struct {
 
 //implement list block
 func (mb *client) PGListBlocks() (list S7BlocksList, err error) {
-	list.OBList, err = mb.pgBlockList(blockOB)
-	//debug
-	fmt.Printf("%v", list.DBList)
-	list.DBList, err = mb.pgBlockList(blockDB)
-	list.FCList, err = mb.pgBlockList(blockFC)
-	list.OBList, err = mb.pgBlockList(blockOB)
-	list.FBList, err = mb.pgBlockList(blockFB)
-	list.SDBList, err = mb.pgBlockList(blockSDB)
-	list.SFBList, err = mb.pgBlockList(blockSFB)
-	list.SFCList, err = mb.pgBlockList(blockSFC)
+	return mb.PGListBlocksContext(context.Background())
+}
+
+// PGListBlocksContext is the context-aware variant of PGListBlocks
+func (mb *client) PGListBlocksContext(ctx context.Context) (list S7BlocksList, err error) {
+	list.DBList, err = mb.pgBlockList(ctx, blockDB)
+	list.FCList, err = mb.pgBlockList(ctx, blockFC)
+	list.OBList, err = mb.pgBlockList(ctx, blockOB)
+	list.FBList, err = mb.pgBlockList(ctx, blockFB)
+	list.SDBList, err = mb.pgBlockList(ctx, blockSDB)
+	list.SFBList, err = mb.pgBlockList(ctx, blockSFB)
+	list.SFCList, err = mb.pgBlockList(ctx, blockSFC)
 	return
 }
 
-func (mb *client) pgBlockList(blockType byte) (arr []int, err error) {
+func (mb *client) pgBlockList(ctx context.Context, blockType byte) (arr []int, err error) {
 	bl := make([]byte, len(s7PGBlockListTelegram))
 	copy(bl, s7PGBlockListTelegram)
 	bl = append(bl, make([]byte, 1)...)
@@ -67,14 +70,27 @@ func (mb *client) pgBlockList(blockType byte) (arr []int, err error) {
 		return
 	}
 	request := NewProtocolDataUnit(bl)
-	//send
-	response, err := mb.send(&request)
-	if err == nil {
-		res := make([]byte, len(response.Data)-33) //remove first 26 byte function and 7 byte header
-		copy(res, response.Data[33:len(response.Data)])
-		arr = dataToBlocks(res)
+	for {
+		//send
+		var response *ProtocolDataUnit
+		if response, err = mb.send(ctx, &request); err != nil {
+			return
+		}
+		if len(response.Data) < 33 {
+			err = fmt.Errorf(ErrorText(errIsoInvalidPDU))
+			return
+		}
+		//remove first 26 byte function and 7 byte header
+		arr = append(arr, dataToBlocks(response.Data[33:])...)
+		if response.Data[26] == 0x00 {
+			return
+		}
+		// long lists come in several parts, ask for the next one
+		next := make([]byte, len(s7PGBlockListNextTelegram))
+		copy(next, s7PGBlockListNextTelegram)
+		next[24] = response.Data[24]
+		request = NewProtocolDataUnit(next)
 	}
-	return
 }
 func dataToBlocks(data []byte) []int {
 	arr := make([]int, len(data)/4)
@@ -0,0 +1,70 @@
+package gos7
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// blockListResponse returns a part of a block list like a CPU sends it: the
+// sequence number of the list, the number of the part as data unit reference and
+// more telling in the last data unit byte that others follow.
+func blockListResponse(request []byte, seq, part byte, more bool, blocks ...int) []byte {
+	length := 33 + 4*len(blocks)
+	response := []byte{3, 0, byte(length >> 8), byte(length), 2, 0xF0, 0x80, 0x32, 7, 0, 0, request[11], request[12],
+		0, 12, byte((4 + 4*len(blocks)) >> 8), byte(4 + 4*len(blocks)), 0, 1, 0x12, 8, 0x12, 0x83, 2, seq, part, 0, 0, 0,
+		0xFF, 9, byte((4 * len(blocks)) >> 8), byte(4 * len(blocks))}
+	if more {
+		response[26] = 1
+	}
+	for _, block := range blocks {
+		response = append(response, byte(block>>8), byte(block), 0x22, 0x05)
+	}
+	return response
+}
+
+func TestPGListBlocksParts(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var blocks []int
+	for i := 1; i <= 113; i++ {
+		blocks = append(blocks, i)
+	}
+	// the list of DBs comes in three parts, the others in one
+	var part byte
+	go serveFakePLC(ln, func(request []byte) []byte {
+		if request[14] == 8 {
+			if request[len(request)-1] != blockDB {
+				return blockListResponse(request, 0x2C, 0, false)
+			}
+			part = 1
+			return blockListResponse(request, 0x2C, part, true, blocks[:50]...)
+		}
+		// the next part is asked for with the sequence number of the list
+		want := append([]byte(nil), s7PGBlockListNextTelegram...)
+		want[24] = 0x2C
+		if !bytes.Equal(request[13:], want[13:]) {
+			t.Errorf("unexpected request for the next part: %x", request)
+		}
+		if part++; part == 2 {
+			return blockListResponse(request, 0x2C, part, true, blocks[50:100]...)
+		}
+		return blockListResponse(request, 0x2C, part, false, blocks[100:]...)
+	})
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	if err = handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	list, err := NewClient(handler).PGListBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.DBList, blocks) || part != 3 {
+		t.Fatalf("unexpected list of %d parts: %v", part, list.DBList)
+	}
+}
@@ -71,6 +71,7 @@ const (
 	code7NoPasswordToSet       = 54789
 	code7FunNotAvailable       = 33028
 	code7DataOverPDU           = 34048
+	code7InvalidSZLID          = 54273
 )
 
 //ErrorText return a string error text from error code integer
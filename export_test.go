@@ -0,0 +1,4 @@
+package gos7
+
+// NewTestServerClient exports newTestServerClient to the tests of other packages.
+var NewTestServerClient = newTestServerClient
@@ -4,6 +4,7 @@ package gos7
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 import (
+	"context"
 	"fmt"
 	"strconv"
 )
@@ -16,7 +17,7 @@ type S7Error struct {
 
 // Packager specifies the communication layer.
 type Packager interface {
-	//reserve for future use
+	// Verify checks that the response belongs to the request
 	Verify(request []byte, response []byte) (err error)
 }
 
@@ -36,6 +37,26 @@ type Transporter interface {
 	Send(request []byte) (response []byte, err error)
 }
 
+// SessionParams are the parameters of the S7 session negotiated when connecting.
+type SessionParams struct {
+	PDULength      int // negotiated PDU length
+	MaxAmqCaller   int // max parallel jobs with ack on the client side
+	MaxAmqCallee   int // max parallel jobs with ack on the PLC side
+	ConnectionType int // connectionTypePG, connectionTypeOP or connectionTypeBasic
+}
+
+// SessionReporter is implemented by transporters which report the negotiated
+// session parameters. The client sizes and splits its requests with them.
+type SessionReporter interface {
+	SessionParams() SessionParams
+}
+
+// ContextTransporter is implemented by transporters which can abort a request
+// when its context is done.
+type ContextTransporter interface {
+	SendContext(ctx context.Context, request []byte) (response []byte, err error)
+}
+
 // Error converts known s7 exception code to error message.
 func (e *S7Error) Error() string {
 	/* CPU tells there is no peripheral at address */
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"log/slog"
 	"math"
 	"time"
 )
@@ -17,11 +18,12 @@ type Helper struct{}
 
 //SetValueAt set a value at a position of a byte array,
 //which based on builtin function: https://golang.org/pkg/encoding/binary/#Read
+//Values of unsupported types are logged to the default slog logger and skipped.
 func (s7 *Helper) SetValueAt(buffer []byte, pos int, data interface{}) {
 	buf := new(bytes.Buffer)
 	err := binary.Write(buf, binary.BigEndian, data)
 	if err != nil {
-		fmt.Println("binary.Write failed:", err)
+		slog.Warn("s7: binary.Write failed", "error", err)
 	}
 	copy(buffer[pos:], buf.Bytes())
 }
@@ -31,7 +33,7 @@ func (s7 *Helper) SetValueAt(buffer []byte, pos int, data interface{}) {
 func (s7 *Helper) GetValueAt(buffer []byte, pos int, value interface{}) {
 	buf := bytes.NewReader(buffer[pos:])
 	if err := binary.Read(buf, binary.BigEndian, value); err != nil {
-		fmt.Println("binary.Read failed:", err)
+		slog.Warn("s7: binary.Read failed", "error", err)
 	}
 }
 
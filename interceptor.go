@@ -0,0 +1,71 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+
+	"github.com/punk-one/gos7/s7comm"
+)
+
+// Operation is the logical operation of the client a request belongs to. A
+// read or write larger than a PDU is sent as several requests of the same
+// operation.
+type Operation struct {
+	Function string // one of the Function constants
+	// Area, DBNumber, Start, Amount and WordLen address a single area read or write
+	Area     int
+	DBNumber int
+	Start    int
+	Amount   int
+	WordLen  int
+	// Items are the items of a multi read or write, NckItems those of an NCK one
+	Items    []S7DataItem
+	NckItems []S7NckAddrItem
+}
+
+// Invoker sends a request and returns its response.
+type Invoker func(ctx context.Context, request *ProtocolDataUnit) (*ProtocolDataUnit, error)
+
+// Interceptor wraps the requests a client sends. It gets the operation with
+// OperationFromContext and calls next to send the request. It may observe or
+// modify the request and the response, return a response of its own without
+// calling next, or fail the request with an error.
+type Interceptor func(ctx context.Context, request *ProtocolDataUnit, next Invoker) (*ProtocolDataUnit, error)
+
+type operationKey struct{}
+
+// OperationFromContext returns the operation of the request an Interceptor got.
+func OperationFromContext(ctx context.Context) (Operation, bool) {
+	op, ok := ctx.Value(operationKey{}).(Operation)
+	return op, ok
+}
+
+// withOperation returns ctx for the requests of op.
+func withOperation(ctx context.Context, op Operation) context.Context {
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+// intercept sends the request through the interceptors of the client. Requests
+// of methods which set no operation get the function of the request.
+func (mb *client) intercept(ctx context.Context, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+	if _, ok := OperationFromContext(ctx); !ok {
+		op := Operation{Function: FunctionOther}
+		if frame, err := s7comm.Decode(request.Data); err == nil && frame.PDU != nil {
+			op.Function, _ = jobFunction(frame.PDU)
+		}
+		ctx = withOperation(ctx, op)
+	}
+	return mb.invoker(0)(ctx, request)
+}
+
+// invoker returns the chain from interceptor i on.
+func (mb *client) invoker(i int) Invoker {
+	if i == len(mb.interceptors) {
+		return mb.invoke
+	}
+	return func(ctx context.Context, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		return mb.interceptors[i](ctx, request, mb.invoker(i+1))
+	}
+}
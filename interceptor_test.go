@@ -0,0 +1,66 @@
+package gos7
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestInterceptors(t *testing.T) {
+	server := NewServer()
+	server.SetDB(1, make([]byte, 16))
+	server.SetDB(2, []byte{1, 2, 3, 4})
+	handler, _ := newTestServerClient(t, server)
+
+	var ops []Operation
+	audit := func(ctx context.Context, request *ProtocolDataUnit, next Invoker) (*ProtocolDataUnit, error) {
+		op, _ := OperationFromContext(ctx)
+		ops = append(ops, op)
+		return next(ctx, request)
+	}
+	errProtected := errors.New("DB1 is write protected")
+	protect := func(ctx context.Context, request *ProtocolDataUnit, next Invoker) (*ProtocolDataUnit, error) {
+		if op, _ := OperationFromContext(ctx); op.Function == FunctionWriteVar && op.Area == AreaDB && op.DBNumber == 1 {
+			return nil, errProtected
+		}
+		return next(ctx, request)
+	}
+	cache := make(map[string]*ProtocolDataUnit)
+	cached := func(ctx context.Context, request *ProtocolDataUnit, next Invoker) (*ProtocolDataUnit, error) {
+		key := fmt.Sprintf("% x", request.Data[13:])
+		if response, ok := cache[key]; ok {
+			return response, nil
+		}
+		response, err := next(ctx, request)
+		if err == nil {
+			cache[key] = response
+		}
+		return response, err
+	}
+	client := NewClient(handler, audit, protect, cached)
+
+	if err := client.AGWriteDB(1, 0, 2, []byte{1, 2}); !errors.Is(err, errProtected) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if db, _ := server.DB(1); db[0] != 0 {
+		t.Fatalf("protected DB1 written: %x", db)
+	}
+	buf := make([]byte, 4)
+	if err := client.AGReadDB(2, 0, 4, buf); err != nil {
+		t.Fatal(err)
+	}
+	// the second read is answered from the cache
+	server.SetDB(2, []byte{5, 6, 7, 8})
+	if err := client.AGReadDB(2, 0, 4, buf); err != nil || !bytes.Equal(buf, []byte{1, 2, 3, 4}) {
+		t.Fatalf("unexpected read: %x %v", buf, err)
+	}
+	if _, err := client.PLCGetStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 4 || ops[0].Function != FunctionWriteVar || ops[1].DBNumber != 2 || ops[1].Amount != 4 ||
+		ops[3].Function != FunctionSZL {
+		t.Fatalf("unexpected operations: %+v", ops)
+	}
+}
@@ -0,0 +1,147 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/punk-one/gos7/s7comm"
+)
+
+// plainLog adapts a plain logger to slog once, not on every line.
+type plainLog struct {
+	mu     sync.Mutex
+	plain  *log.Logger
+	logger *slog.Logger
+}
+
+// structured returns l, or the plain logger adapted to slog if only it is set,
+// nil if none is set.
+func (p *plainLog) structured(l *slog.Logger, plain *log.Logger) *slog.Logger {
+	if l != nil || plain == nil {
+		return l
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.plain != plain {
+		p.plain, p.logger = plain, newPlainLogger(plain)
+	}
+	return p.logger
+}
+
+// newPlainLogger returns a slog logger writing to the plain logger.
+func newPlainLogger(plain *log.Logger) *slog.Logger {
+	return slog.New(slog.NewTextHandler(logPrinter{plain}, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{} // the plain logger adds its own
+			}
+			return a
+		},
+	}))
+}
+
+// logPrinter writes the lines of a slog handler to a plain logger, which keeps
+// its prefix and flags.
+type logPrinter struct {
+	*log.Logger
+}
+
+func (p logPrinter) Write(b []byte) (int, error) {
+	return len(b), p.Output(2, string(b))
+}
+
+// log logs at level to Log, or Logger if only it is set.
+func (mb *tcpTransporter) log(level slog.Level, msg string, args ...any) {
+	if logger := mb.plainLog.structured(mb.Log, mb.Logger); logger != nil {
+		logger.Log(context.Background(), level, msg, append([]any{"address", mb.Address}, args...)...)
+	}
+}
+
+// logExchange logs a request with its response: failed requests at error
+// level, requests the PLC refused at warn level, others at debug level.
+func (mb *tcpTransporter) logExchange(request, response []byte, start time.Time, err error) {
+	logger := mb.plainLog.structured(mb.Log, mb.Logger)
+	if logger == nil {
+		return
+	}
+	// the response is only decoded if it can be logged
+	level := slog.LevelError
+	if err == nil {
+		level = slog.LevelWarn
+	}
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+	var errorCode uint16
+	if err == nil {
+		if frame, derr := s7comm.Decode(response); derr == nil && frame.PDU != nil {
+			errorCode = frame.PDU.Error()
+		}
+		if errorCode == 0 {
+			level = slog.LevelDebug
+			if !logger.Enabled(context.Background(), level) {
+				return
+			}
+		}
+	}
+	args := []any{"address", mb.Address, "duration", time.Since(start), "request_bytes", len(request)}
+	args = append(args, requestAttrs(request)...)
+	switch {
+	case err != nil:
+		args = append(args, "error", err)
+	case errorCode != 0:
+		args = append(args, "response_bytes", len(response), "error_code", fmt.Sprintf("0x%04x", errorCode))
+	default:
+		args = append(args, "response_bytes", len(response), "request", s7comm.Dump(request).String(), "response", s7comm.Dump(response).String())
+	}
+	logger.Log(context.Background(), level, "s7: request", args...)
+}
+
+// requestAttrs returns the function and the address of the first item of an S7 request.
+func requestAttrs(request []byte) []any {
+	frame, err := s7comm.Decode(request)
+	if err != nil || frame.PDU == nil {
+		return nil
+	}
+	pdu := frame.PDU
+	if pdu.UserData != nil {
+		return []any{"group", pdu.UserData.Group, "subfunction", pdu.UserData.Subfunction}
+	}
+	args := []any{"function", fmt.Sprintf("0x%02x", pdu.Function)}
+	if len(pdu.Items) > 0 {
+		item := pdu.Items[0]
+		args = append(args, "items", len(pdu.Items))
+		if item.Syntax == s7comm.SyntaxS7Any {
+			start := item.Address >> 3
+			if item.TransportSize == s7wlcounter || item.TransportSize == s7wltimer {
+				start = item.Address
+			}
+			args = append(args, "area", fmt.Sprintf("0x%02x", item.Area), "db", item.DBNumber,
+				"start", start, "amount", item.Count)
+		}
+	}
+	return args
+}
+
+// frameDump is a frame logged as its dump. It is only decoded when it is logged,
+// and slog logs a panic of the decoder as error.
+type frameDump []byte
+
+func (f frameDump) LogValue() slog.Value {
+	return slog.StringValue(s7comm.Dump(f).String())
+}
+
+// log logs at level to Log, or Logger if only it is set.
+func (s *Server) log(level slog.Level, msg string, args ...any) {
+	if logger := s.plainLog.structured(s.Log, s.Logger); logger != nil {
+		logger.Log(context.Background(), level, msg, args...)
+	}
+}
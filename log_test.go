@@ -0,0 +1,93 @@
+package gos7
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStructuredLogging(t *testing.T) {
+	server := NewServer()
+	server.SetDB(1, make([]byte, 16))
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	_, client := newTestServerClient(t, server, func(h *TCPClientHandler) { h.Log = logger })
+	if err := client.AGReadDB(1, 2, 4, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	var connected, read bool
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry map[string]interface{}
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case entry["msg"] == "s7: connected":
+			connected = entry["level"] == "INFO" && entry["rack"] == 0.0 && entry["slot"] == 2.0 && entry["pdu_length"] == 480.0
+		case entry["msg"] == "s7: request" && entry["function"] == "0x04":
+			read = entry["level"] == "DEBUG" && entry["db"] == 1.0 && entry["start"] == 2.0 && entry["amount"] == 4.0 &&
+				entry["address"] == server.Addr().String()
+		}
+	}
+	if !connected || !read {
+		t.Fatalf("unexpected log:\n%s", buf.String())
+	}
+}
+
+func TestPlainLogging(t *testing.T) {
+	var buf bytes.Buffer
+	var p plainLog
+	plain := log.New(&buf, "plc: ", 0)
+	logger := p.structured(nil, plain)
+	if p.structured(nil, plain) != logger {
+		t.Fatal("plain logger adapted again")
+	}
+	logger.Info("s7: connected", "rack", 0)
+	if line := buf.String(); !strings.HasPrefix(line, "plc: level=INFO") || !strings.Contains(line, "rack=0") {
+		t.Fatalf("unexpected log: %q", line)
+	}
+	if p.structured(slog.Default(), plain) != slog.Default() {
+		t.Fatal("Log does not take precedence over Logger")
+	}
+}
+
+func TestServerLogUnexpectedRequest(t *testing.T) {
+	var buf bytes.Buffer
+	server := NewServer()
+	server.Log = slog.New(slog.NewTextHandler(&buf, nil))
+	handler, client := newTestServerClient(t, server)
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Write(isoConnectionRequest(handler.localTSAP, handler.remoteTSAP)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Read(make([]byte, 64)); err != nil {
+		t.Fatal(err)
+	}
+	// a read var job with an empty item address before the communication setup
+	if _, err = conn.Write([]byte{3, 0, 0, 0x1F, 2, 0xF0, 0x80, 0x32, 1, 0, 0, 0, 1, 0, 0x0E, 0, 0, 4, 1,
+		0x12, 0, 0x82, 0x41, 1, 0, 1, 0, 1, 0, 0xC7, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Read(make([]byte, 64)); err == nil {
+		t.Fatal("expected the connection to be dropped")
+	}
+	// the server keeps serving the other connections
+	if _, err = client.PLCGetStatus(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close() // waits for the connections and their logs
+	if log := buf.String(); !strings.Contains(log, "dropping the connection on an unexpected request") || !strings.Contains(log, "request=") {
+		t.Fatalf("unexpected log:\n%s", log)
+	}
+}
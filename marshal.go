@@ -0,0 +1,237 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// memoryLayout lays out the values of a struct like a non-optimized data block
+// of an S7-300/400 and calls leaf with the address of every value.
+type memoryLayout struct {
+	next    int // next free byte
+	bitByte int // byte of the BOOLs in a row
+	bit     int // next bit in bitByte, 0 when no BOOLs are in a row
+	base    int // first byte of the struct laid out
+	path    string
+	index   []int // of the value in the outermost struct, see fieldAt
+	leaf    func(v reflect.Value, a Address) error
+}
+
+// alignByte ends a row of BOOLs.
+func (l *memoryLayout) alignByte() {
+	l.bit = 0
+}
+
+// alignWord moves to the next even byte, where all but the byte sized values,
+// arrays and structs start.
+func (l *memoryLayout) alignWord() {
+	l.alignByte()
+	l.next += l.next % 2
+}
+
+// structValue lays out the fields of struct v. Fields tagged `s7:"-"` are
+// skipped, `s7:"type=STRING[20]"` gives the type of a field or of the elements
+// of an array. An offset in a tag, relative to the struct, has to match the
+// layout.
+func (l *memoryLayout) structValue(v reflect.Value) error {
+	l.alignWord()
+	t, path, index, base := v.Type(), l.path, l.index, l.base
+	l.base = l.next
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("s7")
+		if tag == "-" || !f.IsExported() {
+			continue
+		}
+		l.path = path + "." + f.Name
+		l.index = append(index[:len(index):len(index)], i)
+		if err := l.value(v.Field(i), tag); err != nil {
+			return err
+		}
+	}
+	l.path, l.index, l.base = path, index, base
+	// a struct takes an even number of bytes
+	l.alignWord()
+	return nil
+}
+
+// value lays out v, of the type in tag if there is one.
+func (l *memoryLayout) value(v reflect.Value, tag string) error {
+	switch {
+	case v.Kind() == reflect.Struct && v.Type() != timeType:
+		if _, err := l.placeAt(tag); err != nil {
+			return err
+		}
+		return l.structValue(v)
+	case v.Kind() == reflect.Array:
+		typ, err := l.placeAt(tag)
+		if err != nil {
+			return err
+		}
+		// the elements take the type, not the offset
+		if tag = ""; typ != "" {
+			tag = "type=" + typ
+		}
+		path, index := l.path, l.index
+		for i := 0; i < v.Len(); i++ {
+			l.path = fmt.Sprintf("%s[%d]", path, i)
+			l.index = append(index[:len(index):len(index)], i)
+			if err := l.value(v.Index(i), tag); err != nil {
+				return err
+			}
+		}
+		l.path, l.index = path, index
+		// an array takes an even number of bytes
+		l.alignWord()
+		return nil
+	}
+	a, placed, err := layoutType(v.Type(), tag)
+	if err != nil {
+		return fmt.Errorf("s7: field %s: %w", l.path[1:], err)
+	}
+	offset, bit := a.Start, a.Bit
+	switch size := a.Type.Size(a.Length); {
+	case a.Type == TypeBool:
+		if l.bit == 0 || l.bit == 8 {
+			l.bitByte, l.bit = l.next, 0
+			l.next++
+		}
+		a.Start, a.Bit = l.bitByte, l.bit
+		l.bit++
+	case size == 1:
+		l.alignByte()
+		a.Start = l.next
+		l.next++
+	default:
+		l.alignWord()
+		a.Start = l.next
+		l.next += size
+	}
+	if placed && (offset != a.Start-l.base || bit != a.Bit) {
+		return fmt.Errorf("s7: field %s: offset %d.%d, laid out at %d.%d", l.path[1:], offset, bit, a.Start-l.base, a.Bit)
+	}
+	if l.leaf != nil {
+		if err = l.leaf(v, a); err != nil {
+			return fmt.Errorf("s7: field %s: %w", l.path[1:], err)
+		}
+	}
+	return nil
+}
+
+// layoutType returns the address of a value of Go type t with the s7 tag of its
+// field, see parseFieldTag. placed reports whether the tag gives its offset.
+func layoutType(t reflect.Type, tag string) (a Address, placed bool, err error) {
+	if a, placed, err = parseFieldTag(tag, t); err != nil {
+		return a, placed, err
+	}
+	if a.Count > 0 {
+		return a, placed, fmt.Errorf("the elements of %v are given by a Go array", a.Type)
+	}
+	return a, placed, nil
+}
+
+// placeAt moves to the next even byte, where a struct or an array starts, and
+// checks the offset in its tag. It returns the type in the tag.
+func (l *memoryLayout) placeAt(tag string) (typ string, err error) {
+	l.alignWord()
+	offset, typ, err := parseFieldOptions(normalizeTag(tag))
+	if err != nil {
+		return "", fmt.Errorf("s7: field %s: %w", l.path[1:], err)
+	}
+	if start, err := strconv.Atoi(offset); offset != "" && (err != nil || start != l.next-l.base) {
+		return "", fmt.Errorf("s7: field %s: offset %s, laid out at %d", l.path[1:], offset, l.next-l.base)
+	}
+	return typ, nil
+}
+
+// SizeOf returns the bytes of the struct v in the layout of Marshal.
+func SizeOf(v interface{}) (int, error) {
+	rv, err := marshalValue(v)
+	if err != nil {
+		return 0, err
+	}
+	var l memoryLayout
+	if err = l.structValue(rv); err != nil {
+		return 0, err
+	}
+	return l.next, nil
+}
+
+// marshalValue returns the struct v is or points to.
+func marshalValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return rv, fmt.Errorf("s7: %T is not a struct", v)
+	}
+	return rv, nil
+}
+
+// Marshal encodes the struct v in the memory layout of a non-optimized data
+// block of an S7-300/400, so a struct mirroring a UDT has the offsets TIA Portal
+// shows for it:
+//
+//   - BOOLs in a row are packed into the bits of a byte
+//   - BYTE, CHAR, SINT and USINT start at the next byte
+//   - all other types, STRINGs, arrays and structs start at the next even byte
+//   - arrays and structs take an even number of bytes
+//
+// The S7 type of a field follows from its Go type, e.g. int16 is INT, float32 is
+// REAL, string is STRING[254] and time.Duration is TIME. Go arrays are arrays and
+// a tag gives another type, like `s7:"type=STRING[20]"` or `s7:"type=TOD"`. The
+// tags of ReadStruct are understood as well, their offsets have to match the
+// layout.
+func Marshal(v interface{}) ([]byte, error) {
+	size, err := SizeOf(v)
+	if err != nil {
+		return nil, err
+	}
+	rv, _ := marshalValue(v)
+	b := make([]byte, size)
+	l := memoryLayout{leaf: func(v reflect.Value, a Address) error {
+		if a.Type == TypeBool {
+			if v.Kind() != reflect.Bool {
+				return fmt.Errorf("cannot encode %v to BOOL", v.Type())
+			}
+			if v.Bool() {
+				b[a.Start] |= 1 << a.Bit
+			}
+			return nil
+		}
+		return encodeValue(a.Type, a.Length, v.Interface(), b[a.Start:])
+	}}
+	if err = l.structValue(rv); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Unmarshal decodes data in the layout of Marshal into the struct v points to.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("s7: %T is not a pointer to a struct", v)
+	}
+	size, err := SizeOf(v)
+	if err != nil {
+		return err
+	}
+	if len(data) < size {
+		return fmt.Errorf("s7: %d bytes for %T of %d bytes", len(data), v, size)
+	}
+	l := memoryLayout{leaf: func(v reflect.Value, a Address) error {
+		value, err := a.Decode(data[a.Start:])
+		if err != nil {
+			return err
+		}
+		if !assignable(reflect.TypeOf(value), v.Type()) {
+			return fmt.Errorf("%v cannot hold the %T of %v", v.Type(), value, a.Type)
+		}
+		setField(v, reflect.ValueOf(value))
+		return nil
+	}}
+	return l.structValue(rv.Elem())
+}
@@ -0,0 +1,60 @@
+package gos7
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMarshal(t *testing.T) {
+	type valve struct {
+		Open bool
+	}
+	type udt struct {
+		A    bool          // 0.0
+		B    bool          // 0.1
+		C    byte          // 1
+		D    int16         // 2
+		E    bool          // 4.0
+		F    float32       // 6
+		G    [3]byte       // 10, padded to 14
+		H    string        `s7:"type=STRING[4]"` // 14
+		I    int8          // 20
+		J    valve         // 22
+		K    [10]bool      // 24
+		L    time.Duration // 26
+		Skip int           `s7:"-"`
+	}
+	v := udt{A: true, C: 0x11, D: -2, E: true, F: 1.5, G: [3]byte{1, 2, 3}, H: "ab", I: -1,
+		J: valve{true}, K: [10]bool{9: true}, L: 1500 * time.Millisecond}
+	b, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x01, 0x11, 0xFF, 0xFE, 0x01, 0x00, 0x3F, 0xC0, 0x00, 0x00,
+		0x01, 0x02, 0x03, 0x00, 0x04, 0x02, 'a', 'b', 0x00, 0x00,
+		0xFF, 0x00, 0x01, 0x00, 0x00, 0x02, 0x00, 0x00, 0x05, 0xDC,
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("unexpected layout:\n%x\n%x", b, want)
+	}
+	if n, err := SizeOf(udt{}); n != 30 || err != nil {
+		t.Fatalf("unexpected size: %d %v", n, err)
+	}
+	var got udt
+	if err = Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Fatalf("unexpected struct: %+v", got)
+	}
+	if err = Unmarshal(b[:20], &got); err == nil {
+		t.Fatal("expected error on short data")
+	}
+	var untyped struct{ N int }
+	if _, err = Marshal(untyped); err == nil {
+		t.Fatal("expected error on a field without S7 type")
+	}
+}
@@ -0,0 +1,171 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"time"
+
+	"github.com/punk-one/gos7/s7comm"
+)
+
+// Functions of the jobs reported to an Observer
+const (
+	FunctionReadVar   = "read_var"
+	FunctionWriteVar  = "write_var"
+	FunctionNCKRead   = "nck_read"
+	FunctionNCKWrite  = "nck_write"
+	FunctionSZL       = "szl"
+	FunctionBlockList = "block_list"
+	FunctionBlockInfo = "block_info"
+	FunctionClock     = "clock"
+	FunctionSecurity  = "security"
+	FunctionPIService = "pi_service"
+	FunctionUpload    = "upload"
+	FunctionDownload  = "download"
+	FunctionSetup     = "setup"
+	FunctionOther     = "other"
+)
+
+// JobMetrics are the metrics of one job sent to a PLC.
+type JobMetrics struct {
+	Address  string
+	Function string // one of the Function constants
+	Items    int    // items of a read/write var job
+	// RequestBytes and ResponseBytes are the sizes of the S7 PDUs, which are
+	// at most PDULength, the PDU length negotiated with the PLC
+	RequestBytes  int
+	ResponseBytes int
+	PDULength     int
+	// The job is chunk Chunk of Chunks of an operation split to fit into the
+	// PDU length, both are 1 for a job that was not split
+	Chunk  int
+	Chunks int
+	// Latency is the time until the response, including the retries
+	Latency time.Duration
+	// Retries are the resends after reconnects
+	Retries int
+	// ErrorClass and ErrorCode are the error of the response header, 0 without error
+	ErrorClass byte
+	ErrorCode  byte
+	// ItemErrors are the items whose return code is not success
+	ItemErrors int
+	// Err is the error sending the job, nil if a response arrived
+	Err error
+}
+
+// Utilization returns the share of the negotiated PDU length the larger of
+// request and response uses.
+func (m JobMetrics) Utilization() float64 {
+	if m.PDULength == 0 {
+		return 0
+	}
+	return float64(max(m.RequestBytes, m.ResponseBytes)) / float64(m.PDULength)
+}
+
+// Observer receives the metrics of every job a TCPClientHandler sends. It is
+// called synchronously, after the response arrived.
+type Observer interface {
+	ObserveJob(m JobMetrics)
+}
+
+// ObserverFunc is a function which is an Observer.
+type ObserverFunc func(m JobMetrics)
+
+// ObserveJob calls f(m).
+func (f ObserverFunc) ObserveJob(m JobMetrics) {
+	f(m)
+}
+
+type chunkKey struct{}
+
+type chunk struct {
+	index, count int
+}
+
+// withChunk returns ctx for chunk index of count of a split operation.
+func withChunk(ctx context.Context, index, count int) context.Context {
+	return context.WithValue(ctx, chunkKey{}, chunk{index, count})
+}
+
+// observe reports a job to the Observer.
+func (mb *tcpTransporter) observe(ctx context.Context, request, response []byte, start time.Time, retries int, err error) {
+	m := JobMetrics{
+		Address:   mb.Address,
+		Function:  FunctionOther,
+		PDULength: mb.SessionParams().PDULength,
+		Chunk:     1,
+		Chunks:    1,
+		Latency:   time.Since(start),
+		Retries:   retries,
+		Err:       err,
+	}
+	if c, ok := ctx.Value(chunkKey{}).(chunk); ok {
+		m.Chunk, m.Chunks = c.index, c.count
+	}
+	if frame, derr := s7comm.Decode(request); derr == nil && frame.PDU != nil {
+		m.Function, m.Items = jobFunction(frame.PDU)
+		m.RequestBytes = len(frame.Payload)
+	}
+	if frame, derr := s7comm.Decode(response); err == nil && derr == nil && frame.PDU != nil {
+		pdu := frame.PDU
+		m.ResponseBytes = len(frame.Payload)
+		m.ErrorClass, m.ErrorCode = pdu.ErrorClass, pdu.ErrorCode
+		for _, item := range pdu.DataItems {
+			if item.ReturnCode != s7comm.ReturnCodeOK {
+				m.ItemErrors++
+			}
+		}
+		for _, code := range pdu.ReturnCodes {
+			if code != s7comm.ReturnCodeOK {
+				m.ItemErrors++
+			}
+		}
+		if pdu.UserData != nil && pdu.UserData.ErrorCode != 0 {
+			m.ErrorClass, m.ErrorCode = byte(pdu.UserData.ErrorCode>>8), byte(pdu.UserData.ErrorCode)
+		}
+	}
+	mb.Observer.ObserveJob(m)
+}
+
+// jobFunction returns the function and the item count of a request.
+func jobFunction(pdu *s7comm.PDU) (function string, items int) {
+	if u := pdu.UserData; u != nil {
+		switch {
+		case u.Group == s7comm.GroupCPU && u.Subfunction == s7comm.CPUReadSZL:
+			return FunctionSZL, 0
+		case u.Group == s7comm.GroupBlock && u.Subfunction == s7comm.BlockInfo:
+			return FunctionBlockInfo, 0
+		case u.Group == s7comm.GroupBlock:
+			return FunctionBlockList, 0
+		case u.Group == s7comm.GroupTime:
+			return FunctionClock, 0
+		case u.Group == s7comm.GroupSecurity:
+			return FunctionSecurity, 0
+		}
+		return FunctionOther, 0
+	}
+	nck := len(pdu.Items) > 0 && pdu.Items[0].Syntax == s7comm.SyntaxNCK
+	switch pdu.Function {
+	case s7comm.FuncReadVar:
+		if nck {
+			return FunctionNCKRead, pdu.ItemCount
+		}
+		return FunctionReadVar, pdu.ItemCount
+	case s7comm.FuncWriteVar:
+		if nck {
+			return FunctionNCKWrite, pdu.ItemCount
+		}
+		return FunctionWriteVar, pdu.ItemCount
+	case s7comm.FuncPIService, s7comm.FuncPLCStop:
+		return FunctionPIService, 0
+	case s7comm.FuncStartUpload, s7comm.FuncUpload, s7comm.FuncEndUpload:
+		return FunctionUpload, 0
+	case s7comm.FuncRequestDownload, s7comm.FuncDownloadBlock, s7comm.FuncDownloadEnded:
+		return FunctionDownload, 0
+	case s7comm.FuncSetupCommunication:
+		return FunctionSetup, 0
+	}
+	return FunctionOther, 0
+}
@@ -0,0 +1,57 @@
+package gos7
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestObserver(t *testing.T) {
+	server := NewServer()
+	server.PDULength = 240
+	server.SetDB(1, make([]byte, 600))
+	var jobs []JobMetrics
+	collector := NewPrometheusCollector()
+	observer := ObserverFunc(func(m JobMetrics) {
+		jobs = append(jobs, m)
+		collector.ObserveJob(m)
+	})
+	_, client := newTestServerClient(t, server, func(h *TCPClientHandler) { h.Observer = observer })
+	// larger than a PDU, the read is split into 3 chunks
+	if err := client.AGReadDB(1, 0, 600, make([]byte, 600)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.AGReadDB(2, 0, 2, make([]byte, 2)); err == nil {
+		t.Fatal("expected error reading a missing DB")
+	}
+	if len(jobs) != 4 {
+		t.Fatalf("unexpected jobs: %+v", jobs)
+	}
+	for i, m := range jobs[:3] {
+		if m.Function != FunctionReadVar || m.Items != 1 || m.Chunk != i+1 || m.Chunks != 3 || m.PDULength != 240 ||
+			m.ResponseBytes > 240 || m.Utilization() < 0.5 {
+			t.Fatalf("unexpected job %d: %+v", i, m)
+		}
+	}
+	if m := jobs[3]; m.Chunks != 1 || m.ItemErrors != 1 || m.Err != nil {
+		t.Fatalf("unexpected job: %+v", m)
+	}
+
+	var buf bytes.Buffer
+	if _, err := collector.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	labels := fmt.Sprintf(`address="%s",function="read_var"`, server.Addr())
+	for _, line := range []string{
+		"gos7_jobs_total{" + labels + "} 4",
+		"gos7_job_chunks_total{" + labels + "} 3",
+		"gos7_job_errors_total{" + labels + `,class="item"} 1`,
+		"gos7_job_duration_seconds_count{" + labels + "} 4",
+		"gos7_pdu_length_bytes{address=\"" + server.Addr().String() + "\"} 240",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("missing %s in:\n%s", line, buf.String())
+		}
+	}
+}
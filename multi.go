@@ -4,8 +4,11 @@ package gos7
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
+
+	"github.com/punk-one/gos7/s7comm"
 )
 
 // S7DataItem which expose as S7DataItem to use in Multiple read/write
@@ -55,6 +58,11 @@ type S7NckReturnCode byte
 
 // implement WriteMulti
 func (mb *client) AGWriteMulti(dataItems []S7DataItem, itemsCount int) (err error) {
+	return mb.AGWriteMultiContext(context.Background(), dataItems, itemsCount)
+}
+
+// AGWriteMultiContext is the context-aware variant of AGWriteMulti
+func (mb *client) AGWriteMultiContext(ctx context.Context, dataItems []S7DataItem, itemsCount int) (err error) {
 	// Checks items
 	if itemsCount > 20 { //max variable is 20
 		err = fmt.Errorf(ErrorText(errCliTooManyItems))
@@ -106,8 +114,7 @@ func (mb *client) AGWriteMulti(dataItems []S7DataItem, itemsCount int) (err erro
 			itemDataSize = dataItems[i].Amount
 			binary.BigEndian.PutUint16(s7ItemWrite[2:], uint16(itemDataSize))
 			break
-		case s7wlcounter:
-		case s7wltimer:
+		case s7wlcounter, s7wltimer:
 			s7ItemWrite[1] = tsResOctet
 			itemDataSize = dataItems[i].Amount * 2
 			binary.BigEndian.PutUint16(s7ItemWrite[2:], uint16(itemDataSize))
@@ -134,36 +141,32 @@ func (mb *client) AGWriteMulti(dataItems []S7DataItem, itemsCount int) (err erro
 		offset = offset + itemDataSize + 4
 		dataLength = dataLength + itemDataSize + 4
 	}
-	tt, _ := interface{}(mb.transporter).(*TCPClientHandler)
 	//Checks the size
-	if offset > tt.PDULength {
+	if offset > mb.session().PDULength {
 		err = fmt.Errorf(ErrorText(errCliSizeOverPDU))
 		return
 	}
 	binary.BigEndian.PutUint16(s7Multi[2:], uint16(offset))      // Whole size
 	binary.BigEndian.PutUint16(s7Multi[15:], uint16(dataLength)) // Whole size
 	request := NewProtocolDataUnit(s7Multi)
-	//debug
-	fmt.Printf("%d", s7Multi)
 	//send
-	response, err := mb.send(&request)
+	ctx = withOperation(ctx, Operation{Function: FunctionWriteVar, Items: dataItems[:itemsCount]})
+	response, err := mb.send(ctx, &request)
 	if err == nil {
-		// Check Global Operation Result
-		cpuErr := CPUError(uint(binary.BigEndian.Uint16(response.Data[17:])))
-		if cpuErr != 0 {
-			err = fmt.Errorf(ErrorText(cpuErr))
+		var pdu *s7comm.PDU
+		if pdu, err = decodeAckData(response, s7WriteVarFunction); err != nil {
 			return
 		}
-		if itemsWritten := int(response.Data[20]); itemsWritten != itemsCount || itemsWritten > 20 { //max var = 20
+		if itemsWritten := len(pdu.ReturnCodes); itemsWritten != itemsCount || itemsWritten > 20 { //max var = 20
 			err = fmt.Errorf(ErrorText(errCliInvalidPlcAnswer))
 			return
 		}
-		for i := 0; i < itemsCount; i++ {
-			if response.Data[i+21] == 0xFF {
+		for i, code := range pdu.ReturnCodes {
+			if code == 0xFF {
 
 				dataItems[i].Error = ""
 			} else {
-				dataItems[i].Error = ErrorText(CPUError(uint(response.Data[i+21])))
+				dataItems[i].Error = ErrorText(CPUError(uint(code)))
 			}
 		}
 	}
@@ -172,6 +175,11 @@ func (mb *client) AGWriteMulti(dataItems []S7DataItem, itemsCount int) (err erro
 
 // implement ReadMulti
 func (mb *client) AGReadMulti(dataItems []S7DataItem, itemsCount int) (err error) {
+	return mb.AGReadMultiContext(context.Background(), dataItems, itemsCount)
+}
+
+// AGReadMultiContext is the context-aware variant of AGReadMulti
+func (mb *client) AGReadMultiContext(ctx context.Context, dataItems []S7DataItem, itemsCount int) (err error) {
 	// Checks items
 	if itemsCount > 20 { //max variable is 20
 		err = fmt.Errorf(ErrorText(errCliTooManyItems))
@@ -215,57 +223,35 @@ func (mb *client) AGReadMulti(dataItems []S7DataItem, itemsCount int) (err error
 		s7Multi = append(s7Multi, s7Item...)
 		offset += len(s7Item)
 	}
-	tt, _ := interface{}(mb.transporter).(*TCPClientHandler)
-	if offset > tt.PDULength {
+	if offset > mb.session().PDULength {
 		err = fmt.Errorf(ErrorText(errCliSizeOverPDU))
 		return
 	}
 	binary.BigEndian.PutUint16(s7Multi[2:], uint16(offset)) // Whole size
 	request := NewProtocolDataUnit(s7Multi)
 	//send
-	response, err := mb.send(&request)
+	ctx = withOperation(ctx, Operation{Function: FunctionReadVar, Items: dataItems[:itemsCount]})
+	response, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
-	// Check ISO Length
-	resLength := len(response.Data)
-	if resLength < 22 {
-		err = fmt.Errorf(ErrorText(errIsoInvalidPDU)) // PDU too Small
-		return
-	}
-	// Check Global Operation Result
-	cpuErr := CPUError(uint(binary.BigEndian.Uint16(response.Data[17:])))
-	if cpuErr != 0 {
-		err = fmt.Errorf(ErrorText(cpuErr))
+	pdu, err := decodeAckData(response, s7WriteReadFunction)
+	if err != nil {
 		return
 	}
 	// Get true ItemsCount
-	itemsRead := int(response.Data[20])
-	s7ItemRead := make([]byte, 1024)
+	itemsRead := len(pdu.DataItems)
 	if itemsRead != itemsCount || itemsRead > 20 { //max var
 		err = fmt.Errorf(ErrorText(errCliInvalidPlcAnswer))
 		return
 	}
 	// Get Data
-	offset = 21
-	for i := 0; i < itemsCount; i++ {
-		// Get the Item
-		copy(s7ItemRead[0:resLength-offset], response.Data[offset:resLength])
-		if s7ItemRead[0] == 255 {
-			itemSize := int(binary.BigEndian.Uint16(s7ItemRead[2:]))
-			item1 := s7ItemRead[1]
-			if item1 != tsResOctet && item1 != tsResReal && item1 != tsResBit {
-				itemSize = itemSize >> 3
-			}
-			copy(dataItems[i].Data[0:], s7ItemRead[4:4+itemSize])
+	for i, item := range pdu.DataItems {
+		if item.ReturnCode == 255 {
+			copy(dataItems[i].Data[0:], item.Data)
 			dataItems[i].Error = ""
-			if itemSize%2 != 0 {
-				itemSize++ // Odd size are rounded
-			}
-			offset = offset + 4 + itemSize
 		} else {
-			dataItems[i].Error = ErrorText(CPUError(uint(s7ItemRead[0])))
-			offset += 4 // Skip the Item header
+			dataItems[i].Error = ErrorText(CPUError(uint(item.ReturnCode)))
 		}
 	}
 
@@ -0,0 +1,34 @@
+package gos7
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestAGWriteMultiCounter(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	requests := make(chan []byte, 1)
+	go serveFakePLC(ln, func(request []byte) []byte {
+		requests <- request
+		return []byte{3, 0, 0, 22, 2, 0xF0, 0x80, 0x32, 3, 0, 0, request[11], request[12], 0, 2, 0, 1, 0, 0,
+			5, 1, 0xFF}
+	})
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	if err = handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	items := []S7DataItem{{Area: s7areact, WordLen: s7wlcounter, Start: 3, Amount: 1, Data: []byte{0x01, 0x23}}}
+	if err = NewClient(handler).AGWriteMulti(items, 1); err != nil {
+		t.Fatal(err)
+	}
+	// a counter is written as 2 octets
+	if request := <-requests; !bytes.HasSuffix(request, []byte{0, tsResOctet, 0, 2, 0x01, 0x23}) {
+		t.Fatalf("unexpected request: %x", request)
+	}
+}
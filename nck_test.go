@@ -0,0 +1,35 @@
+package gos7
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestAGReadMultiNCK(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serveFakePLC(ln, func(request []byte) []byte {
+		// items of 3 and 2 octets, without fill byte
+		return []byte{3, 0, 0, 34, 2, 0xF0, 0x80, 0x32, 3, 0, 0, request[11], request[12], 0, 2, 0, 13, 0, 0,
+			4, 2,
+			0xFF, 9, 0, 3, 1, 2, 3,
+			0xFF, 9, 0, 2, 4, 5}
+	})
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	if err = handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	addrItems := []S7NckAddrItem{{Area: 2, Unit: 1, Column: 2, Line: 1, Module: 0x7F}, {Area: 2, Unit: 1, Column: 3, Line: 1, Module: 0x7F}}
+	items, err := NewClient(handler).AGReadMultiNCK(&addrItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*items) != 2 || !bytes.Equal((*items)[0].Data, []byte{1, 2, 3}) || !bytes.Equal((*items)[1].Data, []byte{4, 5}) {
+		t.Fatalf("unexpected items: %+v", *items)
+	}
+}
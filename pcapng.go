@@ -0,0 +1,292 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	pcapngSHB        = 0x0A0D0D0A // section header block
+	pcapngIDB        = 0x00000001 // interface description block
+	pcapngEPB        = 0x00000006 // enhanced packet block
+	pcapngByteOrder  = 0x1A2B3C4D
+	pcapngEthernet   = 1 // link type
+	pcapHeaderSize   = 14 + 20 + 20
+	pcapMSS          = 1460 // payload is split into segments of at most this size
+	pcapFirstPort    = 49152
+	tcpFlagFIN       = 0x01
+	tcpFlagSYN       = 0x02
+	tcpFlagPSH       = 0x08
+	tcpFlagACK       = 0x10
+	pcapLocalMAC     = "\x02\x00\x00\x00\x00\x01"
+	pcapRemoteMAC    = "\x02\x00\x00\x00\x00\x02"
+	pcapDefaultLocal = "\x0A\x00\x00\x01"
+	pcapDefaultPeer  = "\x0A\x00\x00\x02"
+)
+
+// PcapWriter writes the traffic of connections to a pcapng file which opens in
+// Wireshark. Every connection gets synthesized Ethernet, IPv4 and TCP headers,
+// including the TCP handshake, and the PLC side is always shown on port 102, so
+// that the TPKT, COTP and S7comm dissectors pick it up. Set it as Capture of a
+// TCPClientHandler before Connect:
+//
+//	handler.Capture = gos7.NewPcapWriter(file)
+type PcapWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	err      error
+	started  bool
+	nextPort uint16
+	ipID     uint16
+}
+
+// NewPcapWriter allocates a new PcapWriter writing to w.
+func NewPcapWriter(w io.Writer) *PcapWriter {
+	return &PcapWriter{w: w, nextPort: pcapFirstPort}
+}
+
+// Err returns the first error writing the file. The connections work regardless.
+func (p *PcapWriter) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Conn returns conn with its traffic written to the file, as if conn was the
+// client side of a TCP connection to a PLC.
+func (p *PcapWriter) Conn(conn net.Conn) net.Conn {
+	if c, ok := conn.(*pcapConn); ok && c.p == p {
+		return conn
+	}
+	c := &pcapConn{Conn: conn, p: p}
+	copy(c.localIP[:], pcapDefaultLocal)
+	copy(c.remoteIP[:], pcapDefaultPeer)
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok && addr.IP.To4() != nil {
+		copy(c.localIP[:], addr.IP.To4())
+		c.localPort = uint16(addr.Port)
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && addr.IP.To4() != nil {
+		copy(c.remoteIP[:], addr.IP.To4())
+	}
+	p.mu.Lock()
+	if c.localPort == 0 {
+		c.localPort = p.nextPort
+		p.nextPort++
+	}
+	p.mu.Unlock()
+	c.segment(true, tcpFlagSYN, nil)
+	c.segment(false, tcpFlagSYN|tcpFlagACK, nil)
+	c.segment(true, tcpFlagACK, nil)
+	return c
+}
+
+// writePacket writes one Ethernet frame as enhanced packet block.
+func (p *PcapWriter) writePacket(t time.Time, frame []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return
+	}
+	var buf []byte
+	if !p.started {
+		p.started = true
+		buf = pcapngBlock(buf, pcapngSHB, func(body []byte) []byte {
+			body = binary.LittleEndian.AppendUint32(body, pcapngByteOrder)
+			body = binary.LittleEndian.AppendUint16(body, 1) // version 1.0
+			body = binary.LittleEndian.AppendUint16(body, 0)
+			return binary.LittleEndian.AppendUint64(body, 0xFFFFFFFFFFFFFFFF) // section length unknown
+		})
+		buf = pcapngBlock(buf, pcapngIDB, func(body []byte) []byte {
+			body = binary.LittleEndian.AppendUint16(body, pcapngEthernet)
+			body = binary.LittleEndian.AppendUint16(body, 0)
+			return binary.LittleEndian.AppendUint32(body, 0) // no snap length
+		})
+	}
+	buf = pcapngBlock(buf, pcapngEPB, func(body []byte) []byte {
+		ts := uint64(t.UnixMicro())                      // default resolution of microseconds
+		body = binary.LittleEndian.AppendUint32(body, 0) // interface
+		body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+		body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(frame)))
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(frame)))
+		body = append(body, frame...)
+		return append(body, make([]byte, -len(frame)&3)...)
+	})
+	_, p.err = p.w.Write(buf)
+}
+
+// pcapngBlock appends a block with the body written by fill.
+func pcapngBlock(buf []byte, blockType uint32, fill func([]byte) []byte) []byte {
+	start := len(buf)
+	buf = binary.LittleEndian.AppendUint32(buf, blockType)
+	buf = binary.LittleEndian.AppendUint32(buf, 0)
+	buf = fill(buf)
+	length := uint32(len(buf) - start + 4)
+	binary.LittleEndian.PutUint32(buf[start+4:], length)
+	return binary.LittleEndian.AppendUint32(buf, length)
+}
+
+// pcapConn is a connection whose traffic is written to a PcapWriter.
+type pcapConn struct {
+	net.Conn
+	p *PcapWriter
+
+	mu                  sync.Mutex
+	localIP, remoteIP   [4]byte
+	localPort           uint16
+	localSeq, remoteSeq uint32 // next sequence number of each side
+	localFIN, remoteFIN bool
+
+	received []byte // received data not written yet, used by Read only
+}
+
+func (c *pcapConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	// the received data is written as one segment per TPKT frame, not as the
+	// pieces the reader asked for
+	c.received = append(c.received, b[:n]...)
+	for len(c.received) >= tpktHSize {
+		length := int(binary.BigEndian.Uint16(c.received[2:]))
+		if c.received[0] != 3 || length < tpktHSize {
+			length = len(c.received)
+		}
+		if length > len(c.received) {
+			break
+		}
+		c.segment(false, tcpFlagPSH|tcpFlagACK, c.received[:length])
+		c.received = c.received[length:]
+	}
+	if err == io.EOF {
+		if len(c.received) > 0 {
+			c.segment(false, tcpFlagPSH|tcpFlagACK, c.received)
+			c.received = nil
+		}
+		c.fin(false)
+	}
+	return
+}
+
+func (c *pcapConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	if n > 0 {
+		c.segment(true, tcpFlagPSH|tcpFlagACK, b[:n])
+	}
+	return
+}
+
+func (c *pcapConn) Close() error {
+	c.fin(true)
+	return c.Conn.Close()
+}
+
+// fin writes the FIN of one side and the ACK of the other one, once.
+func (c *pcapConn) fin(local bool) {
+	c.mu.Lock()
+	done := &c.remoteFIN
+	if local {
+		done = &c.localFIN
+	}
+	sent := *done
+	*done = true
+	c.mu.Unlock()
+	if !sent {
+		c.segment(local, tcpFlagFIN|tcpFlagACK, nil)
+		c.segment(!local, tcpFlagACK, nil)
+	}
+}
+
+// segment writes payload sent by the local or the remote side as TCP segments.
+func (c *pcapConn) segment(local bool, flags byte, payload []byte) {
+	for {
+		n := min(len(payload), pcapMSS)
+		c.packet(local, flags, payload[:n])
+		payload = payload[n:]
+		if len(payload) == 0 {
+			return
+		}
+	}
+}
+
+// packet writes one TCP segment with synthesized Ethernet and IPv4 headers.
+func (c *pcapConn) packet(local bool, flags byte, payload []byte) {
+	c.mu.Lock()
+	srcMAC, dstMAC := pcapLocalMAC, pcapRemoteMAC
+	srcIP, dstIP := c.localIP, c.remoteIP
+	srcPort, dstPort := c.localPort, uint16(isoTCP)
+	seq, ack := &c.localSeq, &c.remoteSeq
+	if !local {
+		srcMAC, dstMAC = dstMAC, srcMAC
+		srcIP, dstIP = dstIP, srcIP
+		srcPort, dstPort = dstPort, srcPort
+		seq, ack = ack, seq
+	}
+	frame := make([]byte, pcapHeaderSize+len(payload))
+	copy(frame[0:], dstMAC)
+	copy(frame[6:], srcMAC)
+	binary.BigEndian.PutUint16(frame[12:], 0x0800) // IPv4
+
+	ip := frame[14:34]
+	ip[0] = 0x45 // version 4, 20 bytes header
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(frame)-14))
+	c.p.mu.Lock()
+	c.p.ipID++
+	binary.BigEndian.PutUint16(ip[4:], c.p.ipID)
+	c.p.mu.Unlock()
+	ip[6] = 0x40 // don't fragment
+	ip[8] = 64   // TTL
+	ip[9] = 6    // TCP
+	copy(ip[12:], srcIP[:])
+	copy(ip[16:], dstIP[:])
+	binary.BigEndian.PutUint16(ip[10:], internetChecksum(0, ip))
+
+	tcp := frame[34:]
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], *seq)
+	if flags&tcpFlagACK != 0 {
+		binary.BigEndian.PutUint32(tcp[8:], *ack)
+	}
+	tcp[12] = 5 << 4 // 20 bytes header
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 0xFFFF) // window
+	copy(tcp[20:], payload)
+	pseudo := make([]byte, 12)
+	copy(pseudo, srcIP[:])
+	copy(pseudo[4:], dstIP[:])
+	pseudo[9] = 6
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:], internetChecksum(sum16(0, pseudo), tcp))
+
+	*seq += uint32(len(payload))
+	if flags&(tcpFlagSYN|tcpFlagFIN) != 0 {
+		*seq++
+	}
+	c.mu.Unlock()
+	c.p.writePacket(time.Now(), frame)
+}
+
+// sum16 adds the 16 bit words of b to the one's complement sum.
+func sum16(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 != 0 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// internetChecksum returns the checksum of RFC 1071 over b, continuing sum.
+func internetChecksum(sum uint32, b []byte) uint16 {
+	sum = sum16(sum, b)
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,51 @@
+package gos7
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestPcapCapture(t *testing.T) {
+	server := NewServer()
+	server.SetDB(1, make([]byte, 4))
+	var capture bytes.Buffer
+	handler, client := newTestServerClient(t, server, func(h *TCPClientHandler) { h.Capture = NewPcapWriter(&capture) })
+	if err := client.AGReadDB(1, 0, 4, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	handler.Close()
+	if err := handler.Capture.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var frames [][]byte
+	data := capture.Bytes()
+	for len(data) >= 12 {
+		blockType := binary.LittleEndian.Uint32(data)
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length < 12 || int(length) > len(data) || binary.LittleEndian.Uint32(data[length-4:]) != length {
+			t.Fatalf("invalid block of length %d", length)
+		}
+		if blockType == 6 {
+			frames = append(frames, data[28:28+binary.LittleEndian.Uint32(data[20:])])
+		}
+		data = data[length:]
+	}
+	if len(data) != 0 || len(frames) != 3+6+2 {
+		t.Fatalf("unexpected capture: %d frames, %d bytes left", len(frames), len(data))
+	}
+	// handshake, then the CR and the CC from port 102
+	if frames[0][47] != tcpFlagSYN || frames[1][47] != tcpFlagSYN|tcpFlagACK {
+		t.Fatalf("unexpected handshake: % x", frames[:2])
+	}
+	if cr := frames[3][pcapHeaderSize:]; len(cr) < 6 || cr[5] != cotpCR {
+		t.Fatalf("unexpected CR: % x", cr)
+	}
+	if binary.BigEndian.Uint16(frames[4][34:]) != 102 {
+		t.Fatalf("unexpected source port of the CC: % x", frames[4][34:36])
+	}
+	if internetChecksum(0, frames[3][14:34]) != 0 {
+		t.Fatal("invalid IP checksum")
+	}
+}
@@ -0,0 +1,251 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// pipeline multiplexes concurrent jobs over one connection. Every job gets a
+// unique PDU reference, up to MaxAmqCallee jobs are outstanding at a time and
+// the responses are matched back to their callers by reference.
+type pipeline struct {
+	mb        *tcpTransporter
+	conn      net.Conn
+	timeout   time.Duration
+	maxLength int
+	tpduSize  int
+	slots     chan struct{} // one token per outstanding job
+
+	wmu sync.Mutex // serializes writes on conn
+
+	mu      sync.Mutex
+	pending map[uint16]chan pipelineResult
+	nextRef uint16
+	err     error         // set once the pipeline is broken
+	done    chan struct{} // closed once the pipeline is broken
+}
+
+type pipelineResult struct {
+	response []byte
+	err      error
+}
+
+// newPipeline starts reading responses from conn. Caller must hold the mutex of mb.
+func newPipeline(mb *tcpTransporter, conn net.Conn) *pipeline {
+	jobs := mb.MaxAmqCallee
+	if jobs < 1 {
+		jobs = 1
+	}
+	p := &pipeline{
+		mb:        mb,
+		conn:      conn,
+		timeout:   mb.Timeout,
+		maxLength: mb.maxPduLength(),
+		tpduSize:  mb.tpduSize,
+		slots:     make(chan struct{}, jobs),
+		pending:   make(map[uint16]chan pipelineResult),
+		done:      make(chan struct{}),
+	}
+	// responses are awaited per job, the connection itself never times out
+	_ = conn.SetDeadline(time.Time{})
+	go p.readLoop()
+	return p
+}
+
+// roundTrip sends request and waits for the response with the same PDU reference.
+// The response carries the PDU reference of the request, as if it had been sent unchanged.
+func (p *pipeline) roundTrip(ctx context.Context, request []byte) (response []byte, err error) {
+	if len(request) < 13 || request[7] != 0x32 {
+		return nil, fmt.Errorf("s7: request is not an S7 PDU and cannot be pipelined")
+	}
+	start := time.Now()
+	defer func() { p.mb.logExchange(request, response, start, err) }()
+	deadline := time.Time{}
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d
+	} else if p.timeout > 0 {
+		deadline = time.Now().Add(p.timeout)
+	}
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	// Wait for a free job slot
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-expired:
+		return nil, fmt.Errorf(ErrorText(errCliJobTimeout))
+	case <-p.done:
+		return nil, p.err
+	}
+	ch := make(chan pipelineResult, 1)
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		<-p.slots
+		return nil, p.err
+	}
+	ref := p.allocRef()
+	p.pending[ref] = ch
+	p.mu.Unlock()
+
+	frame := make([]byte, len(request))
+	copy(frame, request)
+	binary.BigEndian.PutUint16(frame[11:], ref)
+	p.wmu.Lock()
+	_ = p.conn.SetWriteDeadline(deadline)
+	err = write(p.conn, frame, p.tpduSize)
+	p.wmu.Unlock()
+	if err != nil {
+		p.fail(err)
+		return nil, err
+	}
+	select {
+	case result := <-ch:
+		if result.err != nil {
+			return nil, result.err
+		}
+		response = result.response
+		copy(response[11:13], request[11:13])
+		return response, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-expired:
+		err = fmt.Errorf(ErrorText(errCliJobTimeout))
+	}
+	// The caller gave up, a late response is dropped
+	p.forget(ref)
+	return nil, err
+}
+
+// allocRef returns an unused PDU reference. Caller must hold p.mu.
+func (p *pipeline) allocRef() uint16 {
+	for {
+		p.nextRef++
+		if _, used := p.pending[p.nextRef]; p.nextRef != 0 && !used {
+			return p.nextRef
+		}
+	}
+}
+
+// forget drops a pending job and frees its slot, unless its response already arrived.
+func (p *pipeline) forget(ref uint16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.pending[ref]; ok {
+		delete(p.pending, ref)
+		<-p.slots
+	}
+}
+
+// readLoop dispatches the responses to the pending jobs until the connection fails.
+func (p *pipeline) readLoop() {
+	for {
+		response, err := receive(p.conn, p.maxLength, p.tpduSize)
+		if err == nil && (len(response) < 13 || response[7] != 0x32) {
+			err = fmt.Errorf(ErrorText(errIsoInvalidPDU))
+		}
+		if err != nil {
+			p.fail(err)
+			return
+		}
+		ref := binary.BigEndian.Uint16(response[11:])
+		p.mu.Lock()
+		if ch, ok := p.pending[ref]; ok {
+			delete(p.pending, ref)
+			<-p.slots
+			ch <- pipelineResult{response: response}
+		} else {
+			p.mb.log(slog.LevelWarn, "s7: dropping response with unknown PDU reference", "pdu_reference", ref)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// fail breaks the pipeline: the connection is closed and all pending jobs get err.
+func (p *pipeline) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return
+	}
+	p.err = err
+	close(p.done)
+	_ = p.conn.Close()
+	for ref, ch := range p.pending {
+		delete(p.pending, ref)
+		ch <- pipelineResult{err: err}
+	}
+}
+
+// broken reports whether the pipeline failed.
+func (p *pipeline) broken() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendPipelined sends the request over the pipeline of the connection,
+// reconnecting according to the Reconnect policy.
+// The resends are counted in retries.
+func (mb *tcpTransporter) sendPipelined(ctx context.Context, request []byte, retries *int) (response []byte, err error) {
+	idempotent := isIdempotentRequest(request)
+	for ; ; *retries++ {
+		var p *pipeline
+		if p, err = mb.activePipeline(ctx); err != nil {
+			return
+		}
+		response, err = p.roundTrip(ctx, request)
+		if err == nil || !p.broken() || mb.Reconnect == nil {
+			return
+		}
+		mb.log(slog.LevelWarn, "s7: connection lost", "error", err)
+		if !idempotent {
+			err = &ConnectionLostError{Address: mb.Address, Err: err}
+			return
+		}
+		if *retries >= mb.Reconnect.MaxRetries {
+			return
+		}
+	}
+}
+
+// activePipeline returns the pipeline of the current connection, reconnecting
+// according to the Reconnect policy when the connection is gone.
+func (mb *tcpTransporter) activePipeline(ctx context.Context) (*pipeline, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	// Set timer to close when idle
+	mb.lastActivity = time.Now()
+	mb.startCloseTimer()
+	if mb.pipe != nil && mb.pipe.broken() {
+		mb.close()
+	}
+	if mb.pipe == nil && mb.conn != nil {
+		mb.pipe = newPipeline(mb, mb.conn)
+	}
+	if mb.pipe == nil {
+		if mb.Reconnect == nil {
+			return nil, fmt.Errorf("Connection to address %s is null", mb.Address)
+		}
+		if err := mb.reconnect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return mb.pipe, nil
+}
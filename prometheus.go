@@ -0,0 +1,244 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the latency histogram.
+var DefaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var utilizationBuckets = []float64{0.1, 0.25, 0.5, 0.75, 0.9, 1}
+
+// errorClassNames are the labels of the S7 error classes of response headers.
+var errorClassNames = map[byte]string{
+	0x81: "application_relationship",
+	0x82: "object_definition",
+	0x83: "no_resources",
+	0x84: "service_processing",
+	0x85: "supplies",
+	0x87: "access",
+}
+
+// PrometheusCollector is an Observer which aggregates the jobs per address and
+// function and writes them in the Prometheus text exposition format, without
+// depending on the Prometheus client library. It serves the metrics over HTTP
+// as well. One collector can observe many handlers:
+//
+//	collector := gos7.NewPrometheusCollector()
+//	handler.Observer = collector
+//	http.Handle("/metrics", collector)
+type PrometheusCollector struct {
+	// Buckets are the upper bounds in seconds of the latency histogram. Set it
+	// before the first job is observed.
+	Buckets []float64
+
+	mu         sync.Mutex
+	jobs       map[jobLabels]*jobStats
+	errors     map[errorLabels]uint64
+	pduLengths map[string]int
+}
+
+type jobLabels struct {
+	address, function string
+}
+
+type errorLabels struct {
+	address, function, class string
+}
+
+type jobStats struct {
+	count, retries, chunked     uint64
+	requestBytes, responseBytes uint64
+	latency, utilization        histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets))
+	}
+	for i, bound := range buckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// NewPrometheusCollector allocates a new PrometheusCollector.
+func NewPrometheusCollector() *PrometheusCollector {
+	return &PrometheusCollector{
+		Buckets:    DefaultLatencyBuckets,
+		jobs:       make(map[jobLabels]*jobStats),
+		errors:     make(map[errorLabels]uint64),
+		pduLengths: make(map[string]int),
+	}
+}
+
+// ObserveJob adds a job to the metrics.
+func (c *PrometheusCollector) ObserveJob(m JobMetrics) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	labels := jobLabels{m.Address, m.Function}
+	stats, ok := c.jobs[labels]
+	if !ok {
+		stats = &jobStats{}
+		c.jobs[labels] = stats
+	}
+	stats.count++
+	stats.retries += uint64(m.Retries)
+	if m.Chunks > 1 {
+		stats.chunked++
+	}
+	stats.requestBytes += uint64(m.RequestBytes)
+	stats.responseBytes += uint64(m.ResponseBytes)
+	stats.latency.observe(c.Buckets, m.Latency.Seconds())
+	if m.PDULength > 0 {
+		c.pduLengths[m.Address] = m.PDULength
+		stats.utilization.observe(utilizationBuckets, m.Utilization())
+	}
+	switch {
+	case m.Err != nil:
+		c.errors[errorLabels{m.Address, m.Function, "transport"}]++
+	case m.ErrorClass != 0:
+		class, ok := errorClassNames[m.ErrorClass]
+		if !ok {
+			class = fmt.Sprintf("0x%02x", m.ErrorClass)
+		}
+		c.errors[errorLabels{m.Address, m.Function, class}]++
+	}
+	if m.ItemErrors > 0 {
+		c.errors[errorLabels{m.Address, m.Function, "item"}] += uint64(m.ItemErrors)
+	}
+}
+
+// ServeHTTP writes the metrics in the text exposition format.
+func (c *PrometheusCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the text exposition format to w.
+func (c *PrometheusCollector) WriteTo(w io.Writer) (n int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	jobs := make([]jobLabels, 0, len(c.jobs))
+	for labels := range c.jobs {
+		jobs = append(jobs, labels)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].address < jobs[j].address ||
+			jobs[i].address == jobs[j].address && jobs[i].function < jobs[j].function
+	})
+	counter := func(name, help string, value func(*jobStats) uint64) {
+		writeHeader(cw, name, help, "counter")
+		for _, labels := range jobs {
+			fmt.Fprintf(cw, "%s{%s} %d\n", name, labels, value(c.jobs[labels]))
+		}
+	}
+	counter("gos7_jobs_total", "Jobs sent to the PLC.", func(s *jobStats) uint64 { return s.count })
+	counter("gos7_job_retries_total", "Resends of jobs after reconnects.", func(s *jobStats) uint64 { return s.retries })
+	counter("gos7_job_chunks_total", "Jobs sent as a chunk of an operation split to fit into the PDU.",
+		func(s *jobStats) uint64 { return s.chunked })
+	counter("gos7_job_request_bytes_total", "Size of the request PDUs.", func(s *jobStats) uint64 { return s.requestBytes })
+	counter("gos7_job_response_bytes_total", "Size of the response PDUs.", func(s *jobStats) uint64 { return s.responseBytes })
+
+	writeHeader(cw, "gos7_job_errors_total", "Failed jobs by error class: transport, an S7 error class, or item for failed items.", "counter")
+	errors := make([]errorLabels, 0, len(c.errors))
+	for labels := range c.errors {
+		errors = append(errors, labels)
+	}
+	sort.Slice(errors, func(i, j int) bool {
+		return fmt.Sprint(errors[i]) < fmt.Sprint(errors[j])
+	})
+	for _, labels := range errors {
+		fmt.Fprintf(cw, "gos7_job_errors_total{%s,class=%s} %d\n",
+			jobLabels{labels.address, labels.function}, quoteLabel(labels.class), c.errors[labels])
+	}
+
+	writeHeader(cw, "gos7_job_duration_seconds", "Latency of the jobs, including the retries.", "histogram")
+	for _, labels := range jobs {
+		writeHistogram(cw, "gos7_job_duration_seconds", labels, c.Buckets, c.jobs[labels].latency)
+	}
+	writeHeader(cw, "gos7_pdu_utilization_ratio", "Share of the negotiated PDU length the jobs use.", "histogram")
+	for _, labels := range jobs {
+		if stats := c.jobs[labels]; stats.utilization.count > 0 {
+			writeHistogram(cw, "gos7_pdu_utilization_ratio", labels, utilizationBuckets, stats.utilization)
+		}
+	}
+
+	writeHeader(cw, "gos7_pdu_length_bytes", "PDU length negotiated with the PLC.", "gauge")
+	addresses := make([]string, 0, len(c.pduLengths))
+	for address := range c.pduLengths {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		fmt.Fprintf(cw, "gos7_pdu_length_bytes{address=%s} %d\n", quoteLabel(address), c.pduLengths[address])
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (l jobLabels) String() string {
+	return "address=" + quoteLabel(l.address) + ",function=" + quoteLabel(l.function)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistogram(w io.Writer, name string, labels jobLabels, buckets []float64, h histogram) {
+	var cumulative uint64
+	for i, bound := range buckets {
+		if i < len(h.counts) {
+			cumulative += h.counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// quoteLabel returns a label value quoted and escaped for the text format.
+func quoteLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+// countingWriter counts the bytes written and keeps the first error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,152 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ReconnectPolicy configures the automatic reconnection of a TCPClientHandler.
+// When the connection is lost, the full connect sequence (TCP, ISO connect and
+// PDU negotiation) is repeated with an exponential backoff. Idempotent jobs
+// (read var, SZL, block info, clock read) are then retried transparently, other
+// jobs fail with a *ConnectionLostError because their outcome is unknown.
+type ReconnectPolicy struct {
+	// MaxRetries is the number of reconnect attempts after the first one failed,
+	// and the number of times a request is resent
+	MaxRetries int
+	// InitialBackoff is the delay before the second reconnect attempt
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts, 0 means no limit
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after every failed attempt, values below 1 are treated as 1
+	Multiplier float64
+	// Jitter randomizes every delay by +/- Jitter of its value (0.0 - 1.0)
+	Jitter float64
+}
+
+// DefaultReconnectPolicy returns a policy with 3 retries, backing off from 100ms up to 5s.
+func DefaultReconnectPolicy() *ReconnectPolicy {
+	return &ReconnectPolicy{
+		MaxRetries:     3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// backoff returns the delay before the given reconnect attempt, attempt 0 has no delay.
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	if attempt <= 0 {
+		return 0
+	}
+	multiplier := math.Max(p.Multiplier, 1)
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * math.Min(p.Jitter, 1) * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// ConnectionLostError is returned when the connection was lost while a
+// non-idempotent job (e.g. a write) was in flight, so it is unknown whether the
+// PLC executed it. The job is not retried.
+type ConnectionLostError struct {
+	Address string
+	Err     error
+}
+
+func (e *ConnectionLostError) Error() string {
+	return fmt.Sprintf("s7: connection to %s lost, write state unknown: %v", e.Address, e.Err)
+}
+
+// Unwrap returns the underlying network error.
+func (e *ConnectionLostError) Unwrap() error {
+	return e.Err
+}
+
+// sendReconnect sends the request, reconnecting and retrying according to the
+// Reconnect policy. Caller must hold the mutex.
+// The resends are counted in retries.
+func (mb *tcpTransporter) sendReconnect(ctx context.Context, request []byte, retries *int) (response []byte, err error) {
+	idempotent := isIdempotentRequest(request)
+	for ; ; *retries++ {
+		if mb.conn == nil {
+			// nothing has been sent yet, so any job may go out after reconnecting
+			if err = mb.reconnect(ctx); err != nil {
+				return
+			}
+		}
+		response, err = mb.send(ctx, request)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		mb.log(slog.LevelWarn, "s7: connection lost", "error", err)
+		mb.close()
+		if !idempotent {
+			err = &ConnectionLostError{Address: mb.Address, Err: err}
+			return
+		}
+		if *retries >= mb.Reconnect.MaxRetries {
+			return
+		}
+	}
+}
+
+// reconnect repeats the connect sequence until it succeeds or the retries are
+// exhausted. Caller must hold the mutex.
+func (mb *tcpTransporter) reconnect(ctx context.Context) (err error) {
+	for attempt := 0; attempt <= mb.Reconnect.MaxRetries; attempt++ {
+		if d := mb.Reconnect.backoff(attempt); d > 0 {
+			timer := time.NewTimer(d)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+		if err = mb.handshake(ctx); err == nil {
+			mb.log(slog.LevelInfo, "s7: reconnected", "attempt", attempt+1)
+			return
+		}
+		mb.log(slog.LevelWarn, "s7: reconnect failed", "attempt", attempt+1, "error", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return
+}
+
+// isIdempotentRequest reports whether a request telegram can safely be sent
+// again: read var, setup communication and the read-only userdata functions.
+func isIdempotentRequest(request []byte) bool {
+	if len(request) < 24 || request[7] != 0x32 {
+		return false
+	}
+	switch request[8] {
+	case 1: // job
+		return request[17] == s7WriteReadFunction || request[17] == 0xF0
+	case 7: // userdata
+		group, subfunction := request[22]&0x0F, request[23]
+		switch group {
+		case 3: // block functions: list, list by type, block info
+			return true
+		case 4: // cpu functions: read SZL
+			return subfunction == 1
+		case 7: // time functions: read clock
+			return subfunction == 1 || subfunction == 3
+		}
+	}
+	return false
+}
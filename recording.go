@@ -0,0 +1,201 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// RecordedExchange is one request sent through a Recorder with its response or
+// error. A recording is a file of exchanges, one JSON object per line.
+type RecordedExchange struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Session  SessionParams `json:"session"`
+	Request  []byte        `json:"request"`
+	Response []byte        `json:"response,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Recorder is a transporter which writes every request passing through it to a
+// recording, together with the response of the wrapped transporter:
+//
+//	recorder := gos7.NewRecorder(handler, file)
+//	client := gos7.NewClient(recorder)
+type Recorder struct {
+	transporter Transporter
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder allocates a new Recorder sending through transporter and writing to w.
+func NewRecorder(transporter Transporter, w io.Writer) *Recorder {
+	return &Recorder{transporter: transporter, enc: json.NewEncoder(w)}
+}
+
+// Send sends the request through the wrapped transporter and records it.
+func (r *Recorder) Send(request []byte) (response []byte, err error) {
+	return r.SendContext(context.Background(), request)
+}
+
+// SendContext is the context-aware variant of Send
+func (r *Recorder) SendContext(ctx context.Context, request []byte) (response []byte, err error) {
+	exchange := RecordedExchange{Time: time.Now(), Request: append([]byte(nil), request...)}
+	if tr, ok := r.transporter.(ContextTransporter); ok {
+		response, err = tr.SendContext(ctx, request)
+	} else if err = ctx.Err(); err == nil {
+		response, err = r.transporter.Send(request)
+	}
+	exchange.Duration = time.Since(exchange.Time)
+	exchange.Session = r.SessionParams()
+	exchange.Response = response
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if werr := r.enc.Encode(exchange); werr != nil && r.err == nil {
+		r.err = werr
+	}
+	return
+}
+
+// Err returns the first error writing the recording. The requests are sent regardless.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Verify verifies the response with the wrapped transporter if it is a Packager.
+func (r *Recorder) Verify(request []byte, response []byte) error {
+	if packager, ok := r.transporter.(Packager); ok {
+		return packager.Verify(request, response)
+	}
+	return nil
+}
+
+// SessionParams reports the session parameters of the wrapped transporter.
+func (r *Recorder) SessionParams() SessionParams {
+	if reporter, ok := r.transporter.(SessionReporter); ok {
+		return reporter.SessionParams()
+	}
+	return SessionParams{}
+}
+
+// ReadRecording reads the exchanges of a recording.
+func ReadRecording(r io.Reader) (exchanges []RecordedExchange, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var exchange RecordedExchange
+		if err = json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return nil, fmt.Errorf("s7: recording line %d: %w", line, err)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, scanner.Err()
+}
+
+// Replayer is a transporter which answers requests from a recording instead of
+// a PLC. A request gets the response of the first recorded exchange with the
+// same request that was not replayed yet, the PDU reference may differ.
+type Replayer struct {
+	mu        sync.Mutex
+	exchanges []RecordedExchange
+	replayed  []bool
+	session   SessionParams
+}
+
+// NewReplayer allocates a new Replayer for the exchanges of a recording.
+func NewReplayer(exchanges []RecordedExchange) *Replayer {
+	p := &Replayer{exchanges: exchanges, replayed: make([]bool, len(exchanges))}
+	if len(exchanges) > 0 {
+		p.session = exchanges[0].Session
+	}
+	return p
+}
+
+// Send returns the recorded response to request.
+func (p *Replayer) Send(request []byte) (response []byte, err error) {
+	return p.SendContext(context.Background(), request)
+}
+
+// SendContext is the context-aware variant of Send
+func (p *Replayer) SendContext(ctx context.Context, request []byte) (response []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, exchange := range p.exchanges {
+		if p.replayed[i] || !sameRequest(exchange.Request, request) {
+			continue
+		}
+		p.replayed[i] = true
+		p.session = exchange.Session
+		if exchange.Error != "" {
+			return nil, errors.New(exchange.Error)
+		}
+		response = append([]byte(nil), exchange.Response...)
+		if isS7PDU(request) && isS7PDU(response) {
+			copy(response[11:13], request[11:13])
+		}
+		return response, nil
+	}
+	return nil, fmt.Errorf("s7: no recorded response to request % x", request)
+}
+
+// Remaining returns the number of recorded exchanges not replayed yet.
+func (p *Replayer) Remaining() (n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, replayed := range p.replayed {
+		if !replayed {
+			n++
+		}
+	}
+	return
+}
+
+// Verify accepts every response.
+func (p *Replayer) Verify(request []byte, response []byte) error {
+	return nil
+}
+
+// SessionParams reports the session parameters of the last replayed exchange.
+func (p *Replayer) SessionParams() SessionParams {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.session
+}
+
+// sameRequest reports whether two requests are equal except for their PDU reference.
+func sameRequest(recorded, request []byte) bool {
+	if len(recorded) != len(request) {
+		return false
+	}
+	if !isS7PDU(request) {
+		return bytes.Equal(recorded, request)
+	}
+	return bytes.Equal(recorded[:11], request[:11]) && bytes.Equal(recorded[13:], request[13:])
+}
+
+// isS7PDU reports whether frame is a TPKT/COTP frame holding an S7 PDU.
+func isS7PDU(frame []byte) bool {
+	return len(frame) >= 13 && frame[7] == 0x32
+}
@@ -0,0 +1,54 @@
+package gos7
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	server := NewServer()
+	server.PDULength = 240
+	server.SetDB(1, []byte{1, 2, 3, 4})
+	server.CPUInfo.SerialNumber = "S C-123"
+	handler, _ := newTestServerClient(t, server)
+	var recording bytes.Buffer
+	recorder := NewRecorder(handler, &recording)
+	client := NewClient(recorder)
+	buf := make([]byte, 4)
+	if err := client.AGReadDB(1, 0, 4, buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetCPUInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	exchanges, err := ReadRecording(&recording)
+	if err != nil || len(exchanges) != 2 || exchanges[0].Session.PDULength != 240 {
+		t.Fatalf("unexpected recording: %+v %v", exchanges, err)
+	}
+	replayer := NewReplayer(exchanges)
+	client = NewClient(replayer)
+	info, err := client.GetCPUInfo()
+	if err != nil || info.SerialNumber != "S C-123" {
+		t.Fatalf("unexpected CPU info: %+v %v", info, err)
+	}
+	buf = make([]byte, 4)
+	if err = client.AGReadDB(1, 0, 4, buf); err != nil || !bytes.Equal(buf, []byte{1, 2, 3, 4}) {
+		t.Fatalf("unexpected read: %x %v", buf, err)
+	}
+	if err = client.AGReadDB(1, 0, 4, buf); err == nil || replayer.Remaining() != 0 {
+		t.Fatalf("replayed an exchange twice: %v", err)
+	}
+
+	// a different PDU reference matches
+	request := append([]byte(nil), exchanges[0].Request...)
+	request[11], request[12] = 0x12, 0x34
+	replayer = NewReplayer(exchanges)
+	response, err := replayer.Send(request)
+	if err != nil || response[11] != 0x12 || response[12] != 0x34 {
+		t.Fatalf("unexpected response: % x %v", response, err)
+	}
+}
@@ -0,0 +1,258 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	szlIDHGroupInfo = 0x0071 // H CPU group information
+	hMasterRack0    = 0x10   // mwstat1: the CPU in rack 0 is master
+)
+
+// RedundantPath is one path into an H-system: the CPU in Rack/Slot, reached
+// directly or through a CP at Address.
+type RedundantPath struct {
+	Address    string
+	Rack, Slot int
+}
+
+// FailoverEvent reports that a RedundantClientHandler switched its traffic to another path.
+type FailoverEvent struct {
+	From, To int   // path indexes
+	Err      error // error on the former path, nil if the master changed while it was still reachable
+}
+
+// RedundantClientHandler connects to all CPUs of an S7-400H or S7-1500R/H system
+// and sends the requests over the path to the master CPU. When that path fails,
+// the master is looked up on the other paths and idempotent requests are resent
+// there. Other requests fail with a *ConnectionLostError as their outcome is unknown.
+type RedundantClientHandler struct {
+	tcpPackager
+	// CheckInterval re-detects the master before a request when the last check is
+	// older, 0 detects it only when the active path fails.
+	CheckInterval time.Duration
+	// OnFailover is called after the traffic switched to another path.
+	OnFailover func(FailoverEvent)
+
+	paths []*TCPClientHandler
+	racks []int
+
+	probeMu   sync.Mutex // serializes the master selection
+	mu        sync.Mutex // guards active and lastCheck
+	active    int
+	lastCheck time.Time
+}
+
+// NewRedundantClientHandler allocates a new RedundantClientHandler with one TCPClientHandler per path.
+func NewRedundantClientHandler(paths ...RedundantPath) *RedundantClientHandler {
+	h := &RedundantClientHandler{}
+	for _, path := range paths {
+		h.paths = append(h.paths, NewTCPClientHandler(path.Address, path.Rack, path.Slot))
+		h.racks = append(h.racks, path.Rack)
+	}
+	return h
+}
+
+// Path returns the handler of path i, e.g. to set its Timeout or Logger before Connect.
+func (h *RedundantClientHandler) Path(i int) *TCPClientHandler {
+	return h.paths[i]
+}
+
+// ActivePath returns the index of the path the requests are sent over.
+func (h *RedundantClientHandler) ActivePath() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.active
+}
+
+// Connect connects all paths and selects the one to the master CPU.
+func (h *RedundantClientHandler) Connect() error {
+	return h.ConnectContext(context.Background())
+}
+
+// ConnectContext is the context-aware variant of Connect
+func (h *RedundantClientHandler) ConnectContext(ctx context.Context) error {
+	if len(h.paths) == 0 {
+		return fmt.Errorf(ErrorText(errCliInvalidParams))
+	}
+	for _, path := range h.paths {
+		// a path which is down now is connected again when it is probed
+		_ = path.ConnectContext(ctx)
+	}
+	order := make([]int, len(h.paths))
+	for i := range order {
+		order[i] = i
+	}
+	h.probeMu.Lock()
+	defer h.probeMu.Unlock()
+	_, err := h.selectMaster(ctx, order)
+	return err
+}
+
+// Close closes the connections of all paths.
+func (h *RedundantClientHandler) Close() (err error) {
+	for _, path := range h.paths {
+		if e := path.Close(); err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// SessionParams reports the session parameters of the active path.
+func (h *RedundantClientHandler) SessionParams() SessionParams {
+	return h.paths[h.ActivePath()].SessionParams()
+}
+
+// Send sends the request over the active path, failing over to the new master when the path fails.
+func (h *RedundantClientHandler) Send(request []byte) (response []byte, err error) {
+	return h.SendContext(context.Background(), request)
+}
+
+// SendContext is the context-aware variant of Send
+func (h *RedundantClientHandler) SendContext(ctx context.Context, request []byte) (response []byte, err error) {
+	active, err := h.activePath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for retry := 0; ; retry++ {
+		response, err = h.paths[active].SendContext(ctx, request)
+		if err == nil || ctx.Err() != nil || retry >= len(h.paths) {
+			return
+		}
+		next, ferr := h.failover(ctx, active, err)
+		if ferr != nil {
+			return nil, ferr
+		}
+		if !isIdempotentRequest(request) {
+			return nil, &ConnectionLostError{Address: h.paths[active].Address, Err: err}
+		}
+		active = next
+	}
+}
+
+// activePath returns the active path, re-detecting the master when CheckInterval elapsed.
+func (h *RedundantClientHandler) activePath(ctx context.Context) (int, error) {
+	if h.CheckInterval <= 0 {
+		return h.ActivePath(), nil
+	}
+	h.probeMu.Lock()
+	defer h.probeMu.Unlock()
+	h.mu.Lock()
+	active, checked := h.active, h.lastCheck
+	h.mu.Unlock()
+	if time.Since(checked) < h.CheckInterval {
+		return active, nil
+	}
+	// the active path is probed first, it is kept while its CPU is master
+	order := []int{active}
+	for i := 1; i < len(h.paths); i++ {
+		order = append(order, (active+i)%len(h.paths))
+	}
+	next, err := h.selectMaster(ctx, order)
+	if err != nil {
+		return active, err
+	}
+	h.switched(FailoverEvent{From: active, To: next})
+	return next, nil
+}
+
+// failover selects the master after path failed with cause. The other paths
+// are probed first, the failed one is reconnected and probed last.
+func (h *RedundantClientHandler) failover(ctx context.Context, failed int, cause error) (int, error) {
+	h.probeMu.Lock()
+	defer h.probeMu.Unlock()
+	if active := h.ActivePath(); active != failed {
+		// another request already switched
+		return active, nil
+	}
+	h.paths[failed].Close()
+	order := make([]int, 0, len(h.paths))
+	for i := 1; i <= len(h.paths); i++ {
+		order = append(order, (failed+i)%len(h.paths))
+	}
+	next, err := h.selectMaster(ctx, order)
+	if err != nil {
+		return failed, fmt.Errorf("s7: failover after %v: %w", cause, err)
+	}
+	h.switched(FailoverEvent{From: failed, To: next, Err: cause})
+	return next, nil
+}
+
+// switched reports a path change to OnFailover.
+func (h *RedundantClientHandler) switched(event FailoverEvent) {
+	if event.From != event.To && h.OnFailover != nil {
+		h.OnFailover(event)
+	}
+}
+
+// selectMaster probes the paths in order and activates the first one to the
+// master CPU. Caller must hold probeMu.
+func (h *RedundantClientHandler) selectMaster(ctx context.Context, order []int) (int, error) {
+	var errs []error
+	for _, i := range order {
+		master, err := h.probe(ctx, i)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("path %d: %w", i, err))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if master {
+			h.mu.Lock()
+			h.active, h.lastCheck = i, time.Now()
+			h.mu.Unlock()
+			return i, nil
+		}
+	}
+	if len(errs) == 0 {
+		return -1, fmt.Errorf("s7: no path to a running master CPU")
+	}
+	return -1, fmt.Errorf("s7: no path to a running master CPU: %w", errors.Join(errs...))
+}
+
+// probe reports whether the CPU behind path i is the running master, the path
+// is connected first if necessary.
+func (h *RedundantClientHandler) probe(ctx context.Context, i int) (master bool, err error) {
+	path := h.paths[i]
+	if !path.connected() {
+		if err = path.ConnectContext(ctx); err != nil {
+			return
+		}
+	}
+	if master, err = isHMaster(ctx, path, h.racks[i]); err != nil {
+		path.Close()
+	}
+	return
+}
+
+// isHMaster reports whether the CPU in rack behind handler is in RUN and master
+// of its H-system. A CPU without H CPU group information is master when in RUN,
+// any other error fails the probe.
+func isHMaster(ctx context.Context, handler *TCPClientHandler, rack int) (bool, error) {
+	c := &client{packager: handler, transporter: handler}
+	status, err := c.PLCGetStatusContext(ctx)
+	if err != nil || status != s7CpuStatusRun {
+		return false, err
+	}
+	szl, _, err := c.readSzl(ctx, szlIDHGroupInfo, 0)
+	if errors.Is(err, errSZLNotAvailable) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(szl.Data) < 4 {
+		return false, fmt.Errorf(ErrorText(errCliInvalidPlcAnswer))
+	}
+	// redinf (word), mwstat1: bit 4/5 is set when the CPU in rack 0/1 is master
+	return szl.Data[2]&(hMasterRack0<<uint(rack)) != 0, nil
+}
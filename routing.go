@@ -0,0 +1,73 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"fmt"
+)
+
+const (
+	routingAddressSize = 23 // subnet ID and node address, padded with zeros
+	routingSubnetSize  = 6  // subnet ID high word, reserved word, subnet ID low word
+)
+
+// Routing is the destination of an S7 routed connection: a CPU in another
+// subnet (MPI, PROFIBUS or Industrial Ethernet) behind the gateway CPU or CP
+// that the TCP connection goes to.
+type Routing struct {
+	// SubnetID is the S7 subnet ID of the destination subnet, shown as "0152-0013"
+	// in the network configuration of STEP 7 and given as 0x01520013 here.
+	SubnetID uint32
+	// Node is the address of the destination in its subnet: the MPI or PROFIBUS
+	// address, or the 4 byte IP address in an Industrial Ethernet subnet.
+	Node []byte
+	// Rack and slot of the destination CPU
+	Rack, Slot int
+	// ConnectionType is 1 = PG (default), 2 = OP or 3 = basic
+	ConnectionType int
+}
+
+// NewTCPClientHandlerWithRouting allocates a new TCPClientHandler connecting
+// through the gateway at address to the CPU described by routing. The routing
+// information is sent in the TSAPs of the ISO connection request, the session
+// is used like a direct one afterwards.
+func NewTCPClientHandlerWithRouting(address string, routing Routing) (*TCPClientHandler, error) {
+	if len(routing.Node) == 0 || len(routing.Node) > routingAddressSize-routingSubnetSize {
+		return nil, fmt.Errorf(ErrorText(errCliInvalidParams))
+	}
+	connectionType := routing.ConnectionType
+	if connectionType == 0 {
+		connectionType = connectionTypePG
+	}
+	subnet := []byte{
+		byte(routing.SubnetID >> 24), byte(routing.SubnetID >> 16),
+		0, 0, // reserved
+		byte(routing.SubnetID >> 8), byte(routing.SubnetID),
+	}
+	h, err := NewTCPClientHandlerWithOptions(ConnectionOptions{
+		Address:    address,
+		LocalTSAP:  routingTSAP(nil, nil, byte(connectionType), 0),
+		RemoteTSAP: routingTSAP(subnet, routing.Node, byte(connectionType), byte(routing.Rack*0x20+routing.Slot)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.ConnectionType = connectionType
+	return h, nil
+}
+
+// routingTSAP returns the TSAP of a routed connection: the number of address
+// blocks, the lengths of subnet ID, node address and connection parameters, the
+// zero padded address area and finally connection type and rack/slot.
+func routingTSAP(subnet, node []byte, connectionType, rackSlot byte) TSAP {
+	tsap := make(TSAP, 4, 4+routingAddressSize+2)
+	tsap[0] = 1 // one address block
+	tsap[1] = byte(len(subnet))
+	tsap[2] = byte(len(node))
+	tsap[3] = 2 // connection type and rack/slot
+	tsap = append(tsap, subnet...)
+	tsap = append(tsap, node...)
+	tsap = append(tsap, make([]byte, routingAddressSize-len(subnet)-len(node))...)
+	return append(tsap, connectionType, rackSlot)
+}
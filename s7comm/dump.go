@@ -0,0 +1,270 @@
+package s7comm
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"fmt"
+	"strings"
+)
+
+var rosctrNames = map[byte]string{
+	Job:      "Job",
+	Ack:      "Ack",
+	AckData:  "Ack_Data",
+	UserData: "Userdata",
+}
+
+var functionNames = map[byte]string{
+	FuncCPUServices:        "CPU services",
+	FuncReadVar:            "Read Var",
+	FuncWriteVar:           "Write Var",
+	FuncRequestDownload:    "Request download",
+	FuncDownloadBlock:      "Download block",
+	FuncDownloadEnded:      "Download ended",
+	FuncStartUpload:        "Start upload",
+	FuncUpload:             "Upload",
+	FuncEndUpload:          "End upload",
+	FuncPIService:          "PI-Service",
+	FuncPLCStop:            "PLC Stop",
+	FuncSetupCommunication: "Setup communication",
+}
+
+var cotpNames = map[byte]string{
+	COTPConnectionRequest: "CR Connect Request",
+	COTPConnectionConfirm: "CC Connect Confirm",
+	COTPDisconnectRequest: "DR Disconnect Request",
+	COTPDisconnectConfirm: "DC Disconnect Confirm",
+	COTPData:              "DT Data",
+}
+
+var areaNames = map[byte]string{
+	0x1C: "CT",
+	0x1D: "TM",
+	0x81: "PE",
+	0x82: "PA",
+	0x83: "MK",
+	0x84: "DB",
+}
+
+var wordLenNames = map[byte]string{
+	0x01: "BIT",
+	0x02: "BYTE",
+	0x03: "CHAR",
+	0x04: "WORD",
+	0x05: "INT",
+	0x06: "DWORD",
+	0x07: "DINT",
+	0x08: "REAL",
+	0x1C: "COUNTER",
+	0x1D: "TIMER",
+}
+
+var transportNames = map[byte]string{
+	TransportNull:  "NULL",
+	TransportBit:   "BIT",
+	TransportByte:  "BYTE/WORD/DWORD",
+	TransportInt:   "INTEGER",
+	TransportDInt:  "DINTEGER",
+	TransportReal:  "REAL",
+	TransportOctet: "OCTET STRING",
+}
+
+var userDataTypeNames = map[byte]string{
+	UserDataPush:     "Push",
+	UserDataRequest:  "Request",
+	UserDataResponse: "Response",
+}
+
+var groupNames = map[byte]string{
+	GroupModeTransition: "Mode-transition",
+	GroupProgrammer:     "Programmer commands",
+	GroupCyclic:         "Cyclic data",
+	GroupBlock:          "Block functions",
+	GroupCPU:            "CPU functions",
+	GroupSecurity:       "Security",
+	GroupTime:           "Time functions",
+	GroupNCProgramming:  "NC programming",
+}
+
+var blockSubfunctionNames = map[byte]string{
+	BlockList:       "List blocks",
+	BlockListOfType: "List blocks of type",
+	BlockInfo:       "Get block info",
+}
+
+// name returns the name of a code, or the code in hex if it has none.
+func name(names map[byte]string, code byte) string {
+	if n, ok := names[code]; ok {
+		return n
+	}
+	return fmt.Sprintf("0x%02x", code)
+}
+
+// Dump is a raw frame that formats as its text dump. It defers decoding to
+// formatting, which makes it cheap to pass to a logger that may discard it.
+type Dump []byte
+
+func (d Dump) String() string {
+	frame, err := Decode(d)
+	if err != nil {
+		return fmt.Sprintf("% x (%v)", []byte(d), err)
+	}
+	return frame.String()
+}
+
+// String returns a text dump of the frame, one header or item per line.
+func (f *Frame) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TPKT: version %d, length %d\n", f.TPKT.Version, f.TPKT.Length)
+	fmt.Fprintf(&b, "COTP: %s", name(cotpNames, f.COTP.PDUType))
+	switch f.COTP.PDUType {
+	case COTPData:
+		if f.COTP.EOT {
+			b.WriteString(", last data unit")
+		}
+	default:
+		fmt.Fprintf(&b, ", dst-ref 0x%04x, src-ref 0x%04x, class %d", f.COTP.DstRef, f.COTP.SrcRef, f.COTP.Class)
+		for _, param := range f.COTP.Params {
+			fmt.Fprintf(&b, ", param 0x%02x: % x", param.Code, param.Value)
+		}
+	}
+	b.WriteString("\n")
+	if f.PDU != nil {
+		f.PDU.dump(&b)
+	} else if len(f.Payload) > 0 {
+		fmt.Fprintf(&b, "  payload: % x\n", f.Payload)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// String returns a text dump of the PDU.
+func (p *PDU) String() string {
+	var b strings.Builder
+	p.dump(&b)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (p *PDU) dump(b *strings.Builder) {
+	fmt.Fprintf(b, "S7: %s, PDU reference %d, parameter %d bytes, data %d bytes",
+		name(rosctrNames, p.ROSCTR), p.Reference, len(p.Param), len(p.Data))
+	if p.ROSCTR == Ack || p.ROSCTR == AckData {
+		fmt.Fprintf(b, ", error 0x%04x", p.Error())
+	}
+	b.WriteString("\n")
+	if p.UserData != nil {
+		p.UserData.dump(b)
+		return
+	}
+	if len(p.Param) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "  Function: %s", name(functionNames, p.Function))
+	switch {
+	case p.Setup != nil:
+		fmt.Fprintf(b, ", max AmQ calling %d, max AmQ called %d, PDU length %d",
+			p.Setup.MaxAmqCaller, p.Setup.MaxAmqCallee, p.Setup.PDULength)
+	case p.PI != nil:
+		fmt.Fprintf(b, ", service %q", p.PI.Name)
+		if len(p.PI.Params) > 0 {
+			fmt.Fprintf(b, ", parameters %q", p.PI.Params)
+		}
+	case p.Function == FuncReadVar || p.Function == FuncWriteVar:
+		fmt.Fprintf(b, ", %d items", p.ItemCount)
+	}
+	b.WriteString("\n")
+	for i, item := range p.Items {
+		fmt.Fprintf(b, "  Item %d: %s\n", i+1, item)
+	}
+	for i, item := range p.DataItems {
+		fmt.Fprintf(b, "  Data %d: %s\n", i+1, item)
+	}
+	for i, code := range p.ReturnCodes {
+		fmt.Fprintf(b, "  Item %d: return code 0x%02x\n", i+1, code)
+	}
+}
+
+// String returns the address of the item, like "DB1 BYTE 0.0 x2".
+func (item Item) String() string {
+	switch {
+	case item.NCK != nil:
+		a := item.NCK
+		return fmt.Sprintf("NCK area %d, unit %d, column %d, line %d, module 0x%02x, line count %d",
+			a.Area, a.Unit, a.Column, a.Line, a.Module, a.LineCount)
+	case item.Syntax == SyntaxS7Any:
+		area := name(areaNames, item.Area)
+		if item.Area == 0x84 {
+			area += fmt.Sprint(item.DBNumber)
+		}
+		address := fmt.Sprintf("%d.%d", item.Address>>3, item.Address&7)
+		if item.TransportSize == 0x1C || item.TransportSize == 0x1D {
+			address = fmt.Sprint(item.Address)
+		}
+		return fmt.Sprintf("%s %s %s x%d", area, name(wordLenNames, item.TransportSize), address, item.Count)
+	}
+	return fmt.Sprintf("syntax 0x%02x: % x", item.Syntax, item.Raw)
+}
+
+// String returns the return code, transport size and data of the item.
+func (item DataItem) String() string {
+	return fmt.Sprintf("return code 0x%02x, %s, %d bytes: % x",
+		item.ReturnCode, name(transportNames, item.TransportSize), item.Length, item.Data)
+}
+
+func (u *UserDataPart) dump(b *strings.Builder) {
+	fmt.Fprintf(b, "  Userdata: %s, %s, subfunction %s, sequence %d",
+		name(userDataTypeNames, u.Type), name(groupNames, u.Group), u.subfunctionName(), u.Sequence)
+	if u.Type != UserDataRequest || u.DataUnitRef != 0 {
+		fmt.Fprintf(b, ", data unit %d", u.DataUnitRef)
+		if !u.LastDataUnit {
+			b.WriteString(", more follows")
+		}
+		if u.ErrorCode != 0 {
+			fmt.Fprintf(b, ", error 0x%04x", u.ErrorCode)
+		}
+	}
+	b.WriteString("\n")
+	if u.ReturnCode != 0 || len(u.Payload) > 0 {
+		fmt.Fprintf(b, "  Data: return code 0x%02x, %s, %d bytes\n",
+			u.ReturnCode, name(transportNames, u.TransportSize), len(u.Payload))
+	}
+	switch {
+	case u.SZL != nil:
+		fmt.Fprintf(b, "  SZL: ID 0x%04x, index 0x%04x", u.SZL.ID, u.SZL.Index)
+		if u.SZL.RecordLength > 0 {
+			fmt.Fprintf(b, ", %d records of %d bytes", u.SZL.RecordCount, u.SZL.RecordLength)
+		}
+		b.WriteString("\n")
+		for i, record := range u.SZL.Records {
+			fmt.Fprintf(b, "  Record %d: % x\n", i+1, record)
+		}
+	case u.Block != nil:
+		fmt.Fprintf(b, "  Block: type %q", u.Block.Type)
+		if u.Subfunction == BlockInfo {
+			fmt.Fprintf(b, ", number %d, file system %q", u.Block.Number, u.Block.FileSystem)
+		}
+		b.WriteString("\n")
+	case u.Subfunction == BlockList && u.Group == GroupBlock:
+		for _, block := range u.Blocks {
+			fmt.Fprintf(b, "  Block type %q: %d blocks\n", block.Type, block.Count)
+		}
+	case u.Subfunction == BlockListOfType && u.Group == GroupBlock:
+		for _, block := range u.Blocks {
+			fmt.Fprintf(b, "  Block %d: flags 0x%02x, language %d\n", block.Number, block.Flags, block.Language)
+		}
+	case len(u.Payload) > 0:
+		fmt.Fprintf(b, "  Payload: % x\n", u.Payload)
+	}
+}
+
+func (u *UserDataPart) subfunctionName() string {
+	switch u.Group {
+	case GroupBlock:
+		return name(blockSubfunctionNames, u.Subfunction)
+	case GroupCPU:
+		if u.Subfunction == CPUReadSZL {
+			return "Read SZL"
+		}
+	}
+	return fmt.Sprintf("0x%02x", u.Subfunction)
+}
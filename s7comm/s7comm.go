@@ -0,0 +1,569 @@
+// Package s7comm decodes S7 communication frames, as exchanged with Siemens
+// S7 PLCs over ISO-on-TCP, into typed structures and a readable text dump.
+//
+//	frame, err := s7comm.Decode(raw)
+//	if err == nil {
+//		fmt.Println(frame)
+//	}
+package s7comm
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// PDU types (ROSCTR)
+const (
+	Job      = 0x01
+	Ack      = 0x02
+	AckData  = 0x03
+	UserData = 0x07
+)
+
+// Functions of job and ack data PDUs
+const (
+	FuncCPUServices        = 0x00
+	FuncReadVar            = 0x04
+	FuncWriteVar           = 0x05
+	FuncRequestDownload    = 0x1A
+	FuncDownloadBlock      = 0x1B
+	FuncDownloadEnded      = 0x1C
+	FuncStartUpload        = 0x1D
+	FuncUpload             = 0x1E
+	FuncEndUpload          = 0x1F
+	FuncPIService          = 0x28
+	FuncPLCStop            = 0x29
+	FuncSetupCommunication = 0xF0
+)
+
+// COTP PDU types
+const (
+	COTPConnectionRequest = 0xE0
+	COTPConnectionConfirm = 0xD0
+	COTPDisconnectRequest = 0x80
+	COTPDisconnectConfirm = 0xC0
+	COTPData              = 0xF0
+)
+
+// Syntax IDs of item addresses
+const (
+	SyntaxS7Any = 0x10
+	SyntaxNCK   = 0x82
+)
+
+// Transport sizes of data items
+const (
+	TransportNull  = 0x00
+	TransportBit   = 0x03
+	TransportByte  = 0x04
+	TransportInt   = 0x05
+	TransportDInt  = 0x06
+	TransportReal  = 0x07
+	TransportOctet = 0x09
+)
+
+// ReturnCodeOK is the return code of a successful data item.
+const ReturnCodeOK = 0xFF
+
+const (
+	protocolID      = 0x32
+	tpktSize        = 4
+	headerSize      = 10 // of job and userdata, ack and ack data add an error code
+	itemSpec        = 0x12
+	userDataHead    = 0x00
+	userDataSetupID = 0x12
+)
+
+// Userdata types
+const (
+	UserDataPush     = 0x0
+	UserDataRequest  = 0x4
+	UserDataResponse = 0x8
+)
+
+// Userdata function groups
+const (
+	GroupModeTransition = 0x0
+	GroupProgrammer     = 0x1
+	GroupCyclic         = 0x2
+	GroupBlock          = 0x3
+	GroupCPU            = 0x4
+	GroupSecurity       = 0x5
+	GroupTime           = 0x7
+	GroupNCProgramming  = 0xF
+)
+
+// Subfunctions of the block and CPU groups
+const (
+	BlockList       = 0x01
+	BlockListOfType = 0x02
+	BlockInfo       = 0x03
+	CPUReadSZL      = 0x01
+)
+
+var errShort = errors.New("s7comm: frame too short")
+
+// Frame is a decoded TPKT frame.
+type Frame struct {
+	TPKT    TPKT
+	COTP    COTP
+	Payload []byte // after the COTP header
+	PDU     *PDU   // nil unless the frame is the last COTP data TPDU holding an S7 PDU
+}
+
+// TPKT is the header of RFC 1006.
+type TPKT struct {
+	Version byte
+	Length  int
+}
+
+// COTP is the header of an ISO 8073 TPDU.
+type COTP struct {
+	Length  int // length indicator
+	PDUType byte
+	DstRef  uint16 // connection TPDUs only
+	SrcRef  uint16
+	Class   byte
+	EOT     bool // data TPDUs only, set on the last TPDU of a PDU
+	Params  []COTPParam
+}
+
+// COTPParam is a parameter of a connection TPDU, like the TSAPs or the TPDU size.
+type COTPParam struct {
+	Code  byte
+	Value []byte
+}
+
+// PDU is a decoded S7 PDU. Param and Data hold the raw parameter and data
+// parts; the typed fields that apply to the PDU are filled from them.
+type PDU struct {
+	ROSCTR     byte
+	Reference  uint16
+	ErrorClass byte // ack and ack data only
+	ErrorCode  byte
+	Param      []byte
+	Data       []byte
+
+	Function    byte          // job and ack data
+	Setup       *Setup        // setup communication
+	ItemCount   int           // read/write var
+	Items       []Item        // read/write var job
+	DataItems   []DataItem    // read var ack data, write var job
+	ReturnCodes []byte        // write var ack data
+	PI          *PIService    // PI service and PLC stop job
+	UserData    *UserDataPart // userdata
+}
+
+// Error returns the error class and code of the header, 0 without error.
+func (p *PDU) Error() uint16 {
+	return uint16(p.ErrorClass)<<8 | uint16(p.ErrorCode)
+}
+
+// Setup are the parameters of setup communication.
+type Setup struct {
+	MaxAmqCaller int
+	MaxAmqCallee int
+	PDULength    int
+}
+
+// Item is the address of one item of a read/write var job.
+type Item struct {
+	Syntax byte
+	// S7ANY
+	TransportSize byte // word length of the elements
+	Count         int
+	DBNumber      int
+	Area          byte
+	Address       int // bit address, element number for counters and timers
+	// NCK
+	NCK *NCKAddress
+	// other syntax IDs
+	Raw []byte
+}
+
+// NCKAddress is the address of an item with syntax ID 0x82 of a SINUMERIK NCK.
+type NCKAddress struct {
+	Area      byte
+	Unit      byte
+	Column    int
+	Line      int
+	Module    byte
+	LineCount byte
+}
+
+// DataItem is one item of the data part. Length is given in bytes, whatever
+// unit the transport size uses on the wire.
+type DataItem struct {
+	ReturnCode    byte
+	TransportSize byte
+	Length        int
+	Data          []byte
+}
+
+// PIService is a program invocation like hot start, cold start or stop.
+type PIService struct {
+	Name   string
+	Params []byte
+}
+
+// UserDataPart is the parameter and data part of a userdata PDU.
+type UserDataPart struct {
+	Type          byte // UserDataPush, UserDataRequest or UserDataResponse
+	Group         byte
+	Subfunction   byte
+	Sequence      byte
+	DataUnitRef   byte // responses and follow-up requests only
+	LastDataUnit  bool
+	ErrorCode     uint16
+	ReturnCode    byte
+	TransportSize byte
+	Payload       []byte
+
+	SZL    *SZL         // read SZL
+	Blocks []BlockEntry // block list responses
+	Block  *BlockRef    // list blocks of type and block info requests
+}
+
+// SZL is a system status list request or response. Records are only split
+// for responses with the SZL header, follow-up parts carry the records only.
+type SZL struct {
+	ID           uint16
+	Index        uint16
+	RecordLength int
+	RecordCount  int
+	Records      [][]byte
+}
+
+// BlockEntry is an entry of a block list. List blocks gives the block type and
+// count, list blocks of type the number, flags and language.
+type BlockEntry struct {
+	Type     byte
+	Count    int
+	Number   int
+	Flags    byte
+	Language byte
+}
+
+// BlockRef is the block a block function asks for.
+type BlockRef struct {
+	Type       byte
+	Number     int // block info only
+	FileSystem byte
+}
+
+// Decode decodes a TPKT frame.
+func Decode(frame []byte) (*Frame, error) {
+	return decode(frame, DecodePDU)
+}
+
+// DecodeHeader decodes a TPKT frame like Decode, but of the PDU only the header,
+// Param, Data and Function. It decodes the responses to NCK reads, whose items
+// cannot be told apart from those of S7ANY reads without the request.
+func DecodeHeader(frame []byte) (*Frame, error) {
+	return decode(frame, decodeHeader)
+}
+
+// decode decodes a TPKT frame, its PDU with decodePDU.
+func decode(frame []byte, decodePDU func([]byte) (*PDU, error)) (*Frame, error) {
+	if len(frame) < tpktSize+2 {
+		return nil, errShort
+	}
+	f := &Frame{TPKT: TPKT{Version: frame[0], Length: int(binary.BigEndian.Uint16(frame[2:]))}}
+	if f.TPKT.Version != 3 {
+		return nil, fmt.Errorf("s7comm: unsupported TPKT version %d", f.TPKT.Version)
+	}
+	if f.TPKT.Length < tpktSize+2 || f.TPKT.Length > len(frame) {
+		return nil, fmt.Errorf("s7comm: TPKT length %d of a %d bytes frame", f.TPKT.Length, len(frame))
+	}
+	frame = frame[:f.TPKT.Length]
+	cotp := frame[tpktSize:]
+	f.COTP.Length = int(cotp[0])
+	if f.COTP.Length+1 > len(cotp) || f.COTP.Length < 1 {
+		return nil, fmt.Errorf("s7comm: COTP length %d exceeds the frame", f.COTP.Length)
+	}
+	f.COTP.PDUType = cotp[1] & 0xF0
+	f.Payload = cotp[1+f.COTP.Length:]
+	header := cotp[1 : 1+f.COTP.Length]
+	switch f.COTP.PDUType {
+	case COTPData:
+		if len(header) < 2 {
+			return nil, errShort
+		}
+		f.COTP.EOT = header[1]&0x80 != 0
+	case COTPConnectionRequest, COTPConnectionConfirm, COTPDisconnectRequest, COTPDisconnectConfirm:
+		if len(header) < 6 {
+			return nil, errShort
+		}
+		f.COTP.DstRef = binary.BigEndian.Uint16(header[1:])
+		f.COTP.SrcRef = binary.BigEndian.Uint16(header[3:])
+		f.COTP.Class = header[5] >> 4
+		for params := header[6:]; len(params) >= 2; {
+			size := int(params[1])
+			if 2+size > len(params) {
+				return nil, fmt.Errorf("s7comm: COTP parameter 0x%02x exceeds the header", params[0])
+			}
+			f.COTP.Params = append(f.COTP.Params, COTPParam{Code: params[0], Value: params[2 : 2+size]})
+			params = params[2+size:]
+		}
+	}
+	if f.COTP.PDUType == COTPData && f.COTP.EOT && len(f.Payload) > 0 {
+		pdu, err := decodePDU(f.Payload)
+		if err != nil {
+			return nil, err
+		}
+		f.PDU = pdu
+	}
+	return f, nil
+}
+
+// DecodePDU decodes an S7 PDU, starting at the protocol ID 0x32.
+func DecodePDU(b []byte) (*PDU, error) {
+	p, err := decodeHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	switch p.ROSCTR {
+	case Job, AckData:
+		err = p.decodeFunction()
+	case UserData:
+		p.UserData, err = decodeUserData(p.Param, p.Data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// decodeHeader decodes the header of an S7 PDU and splits its parameters and
+// data.
+func decodeHeader(b []byte) (*PDU, error) {
+	if len(b) < headerSize {
+		return nil, errShort
+	}
+	if b[0] != protocolID {
+		return nil, fmt.Errorf("s7comm: unknown protocol ID 0x%02x", b[0])
+	}
+	p := &PDU{ROSCTR: b[1], Reference: binary.BigEndian.Uint16(b[4:])}
+	size := headerSize
+	if p.ROSCTR == Ack || p.ROSCTR == AckData {
+		if len(b) < headerSize+2 {
+			return nil, errShort
+		}
+		p.ErrorClass, p.ErrorCode = b[10], b[11]
+		size += 2
+	}
+	paramLength := int(binary.BigEndian.Uint16(b[6:]))
+	dataLength := int(binary.BigEndian.Uint16(b[8:]))
+	if size+paramLength+dataLength > len(b) {
+		return nil, fmt.Errorf("s7comm: parameter length %d and data length %d exceed the PDU", paramLength, dataLength)
+	}
+	p.Param = b[size : size+paramLength]
+	p.Data = b[size+paramLength : size+paramLength+dataLength]
+	if (p.ROSCTR == Job || p.ROSCTR == AckData) && len(p.Param) > 0 {
+		p.Function = p.Param[0]
+	}
+	return p, nil
+}
+
+// decodeFunction decodes the parameters and data of a job or ack data PDU.
+func (p *PDU) decodeFunction() error {
+	if len(p.Param) == 0 {
+		return nil // ack data without parameters carries an error
+	}
+	switch p.Function {
+	case FuncSetupCommunication:
+		if len(p.Param) < 8 {
+			return errShort
+		}
+		p.Setup = &Setup{
+			MaxAmqCaller: int(binary.BigEndian.Uint16(p.Param[2:])),
+			MaxAmqCallee: int(binary.BigEndian.Uint16(p.Param[4:])),
+			PDULength:    int(binary.BigEndian.Uint16(p.Param[6:])),
+		}
+	case FuncReadVar, FuncWriteVar:
+		if len(p.Param) < 2 {
+			return errShort
+		}
+		p.ItemCount = int(p.Param[1])
+		var err error
+		switch {
+		case p.ROSCTR == Job:
+			if p.Items, err = decodeItems(p.Param[2:], p.ItemCount); err == nil && p.Function == FuncWriteVar {
+				nck := len(p.Items) > 0 && p.Items[0].Syntax == SyntaxNCK
+				p.DataItems, err = decodeDataItems(p.Data, p.ItemCount, false, nck)
+			}
+		case p.Function == FuncReadVar:
+			p.DataItems, err = decodeDataItems(p.Data, p.ItemCount, true, false)
+		default:
+			if len(p.Data) < p.ItemCount {
+				return fmt.Errorf("s7comm: %d return codes for %d items", len(p.Data), p.ItemCount)
+			}
+			p.ReturnCodes = p.Data[:p.ItemCount]
+		}
+		return err
+	case FuncPIService:
+		if p.ROSCTR != Job {
+			return nil
+		}
+		// 7 unknown bytes, parameter block length and parameters, service name
+		if len(p.Param) < 10 {
+			return errShort
+		}
+		size := int(binary.BigEndian.Uint16(p.Param[8:]))
+		if 10+size >= len(p.Param) || 11+size+int(p.Param[10+size]) > len(p.Param) {
+			return fmt.Errorf("s7comm: PI service parameter length %d exceeds the parameters", size)
+		}
+		name := p.Param[11+size:]
+		p.PI = &PIService{Params: p.Param[10 : 10+size], Name: string(name[:p.Param[10+size]])}
+	case FuncPLCStop:
+		if p.ROSCTR != Job {
+			return nil
+		}
+		// 5 unknown bytes and the service name
+		if len(p.Param) < 7 || 7+int(p.Param[6]) > len(p.Param) {
+			return errShort
+		}
+		p.PI = &PIService{Name: string(p.Param[7 : 7+int(p.Param[6])])}
+	}
+	return nil
+}
+
+// decodeItems decodes the item addresses of a read/write var job.
+func decodeItems(b []byte, count int) ([]Item, error) {
+	items := make([]Item, 0, count)
+	for i := 0; i < count; i++ {
+		if len(b) < 3 || b[0] != itemSpec || b[1] == 0 || 2+int(b[1]) > len(b) {
+			return nil, fmt.Errorf("s7comm: invalid address of item %d", i+1)
+		}
+		spec := b[2 : 2+int(b[1])]
+		item := Item{Syntax: spec[0]}
+		switch {
+		case item.Syntax == SyntaxS7Any && len(spec) >= 10:
+			item.TransportSize = spec[1]
+			item.Count = int(binary.BigEndian.Uint16(spec[2:]))
+			item.DBNumber = int(binary.BigEndian.Uint16(spec[4:]))
+			item.Area = spec[6]
+			item.Address = int(spec[7])<<16 | int(spec[8])<<8 | int(spec[9])
+		case item.Syntax == SyntaxNCK && len(spec) >= 8:
+			item.NCK = &NCKAddress{
+				Area:      spec[1] >> 5,
+				Unit:      spec[1] & 0x1F,
+				Column:    int(binary.BigEndian.Uint16(spec[2:])),
+				Line:      int(binary.BigEndian.Uint16(spec[4:])),
+				Module:    spec[6],
+				LineCount: spec[7],
+			}
+		default:
+			item.Raw = spec
+		}
+		items = append(items, item)
+		b = b[2+len(spec):]
+	}
+	return items, nil
+}
+
+// decodeDataItems decodes the data items of a read var response or a write
+// var job. Items of odd length but the last are padded to an even length, but
+// those of NCK variables, whose length is in bytes.
+func decodeDataItems(b []byte, count int, response, nck bool) ([]DataItem, error) {
+	items := make([]DataItem, 0, count)
+	for i := 0; i < count; i++ {
+		if response && len(b) >= 1 && b[0] != ReturnCodeOK && len(b) < 4 {
+			// some CPUs only send the return code of a failed item
+			items = append(items, DataItem{ReturnCode: b[0]})
+			b = b[len(b):]
+			continue
+		}
+		if len(b) < 4 {
+			return nil, fmt.Errorf("s7comm: data of item %d missing", i+1)
+		}
+		item := DataItem{ReturnCode: b[0], TransportSize: b[1], Length: int(binary.BigEndian.Uint16(b[2:]))}
+		switch {
+		case nck:
+			// length in bytes
+		case item.TransportSize == TransportBit:
+			item.Length = (item.Length + 7) / 8
+		case item.TransportSize == TransportByte, item.TransportSize == TransportInt, item.TransportSize == TransportDInt:
+			item.Length /= 8 // length in bits
+		}
+		if 4+item.Length > len(b) {
+			return nil, fmt.Errorf("s7comm: data of item %d exceeds the PDU", i+1)
+		}
+		item.Data = b[4 : 4+item.Length]
+		b = b[4+item.Length:]
+		if item.Length%2 != 0 && i < count-1 && len(b) > 0 && !nck {
+			b = b[1:] // fill byte
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// decodeUserData decodes the parameters and data of a userdata PDU.
+func decodeUserData(param, data []byte) (*UserDataPart, error) {
+	if len(param) < 8 || param[0] != userDataHead || param[1] != 0x01 || param[2] != userDataSetupID {
+		return nil, errors.New("s7comm: invalid userdata parameter head")
+	}
+	u := &UserDataPart{
+		Type:        param[5] >> 4,
+		Group:       param[5] & 0x0F,
+		Subfunction: param[6],
+		Sequence:    param[7],
+		// requests without data unit reference are single parts
+		LastDataUnit: true,
+	}
+	if param[3] >= 8 && len(param) >= 12 {
+		u.DataUnitRef = param[8]
+		u.LastDataUnit = param[9] == 0
+		u.ErrorCode = binary.BigEndian.Uint16(param[10:])
+	}
+	if len(data) < 4 {
+		return u, nil
+	}
+	u.ReturnCode, u.TransportSize = data[0], data[1]
+	size := int(binary.BigEndian.Uint16(data[2:]))
+	if 4+size > len(data) {
+		return nil, fmt.Errorf("s7comm: userdata length %d exceeds the PDU", size)
+	}
+	u.Payload = data[4 : 4+size]
+	if u.ReturnCode != ReturnCodeOK {
+		return u, nil
+	}
+	payload := u.Payload
+	switch {
+	case u.Group == GroupCPU && u.Subfunction == CPUReadSZL && len(payload) >= 4:
+		u.SZL = &SZL{ID: binary.BigEndian.Uint16(payload), Index: binary.BigEndian.Uint16(payload[2:])}
+		if u.Type != UserDataResponse || u.DataUnitRef != 0 || len(payload) < 8 {
+			break
+		}
+		u.SZL.RecordLength = int(binary.BigEndian.Uint16(payload[4:]))
+		u.SZL.RecordCount = int(binary.BigEndian.Uint16(payload[6:]))
+		for records := payload[8:]; u.SZL.RecordLength > 0 && len(records) >= u.SZL.RecordLength; {
+			u.SZL.Records = append(u.SZL.Records, records[:u.SZL.RecordLength])
+			records = records[u.SZL.RecordLength:]
+		}
+	case u.Group == GroupBlock && u.Type == UserDataResponse && u.Subfunction == BlockList:
+		for ; len(payload) >= 4; payload = payload[4:] {
+			u.Blocks = append(u.Blocks, BlockEntry{Type: payload[1], Count: int(binary.BigEndian.Uint16(payload[2:]))})
+		}
+	case u.Group == GroupBlock && u.Type == UserDataResponse && u.Subfunction == BlockListOfType:
+		for ; len(payload) >= 4; payload = payload[4:] {
+			u.Blocks = append(u.Blocks, BlockEntry{Number: int(binary.BigEndian.Uint16(payload)), Flags: payload[2], Language: payload[3]})
+		}
+	case u.Group == GroupBlock && u.Type == UserDataRequest && u.Subfunction == BlockListOfType && len(payload) >= 2:
+		u.Block = &BlockRef{Type: payload[1]}
+	case u.Group == GroupBlock && u.Type == UserDataRequest && u.Subfunction == BlockInfo && len(payload) >= 8:
+		u.Block = &BlockRef{Type: payload[1], FileSystem: payload[7]}
+		for _, digit := range payload[2:7] {
+			if digit < '0' || digit > '9' {
+				return nil, fmt.Errorf("s7comm: invalid block number %q", payload[2:7])
+			}
+			u.Block.Number = u.Block.Number*10 + int(digit-'0')
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,147 @@
+package s7comm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecodeReadVar(t *testing.T) {
+	job := []byte{3, 0, 0, 31, 2, 0xF0, 0x80, 0x32, 1, 0, 0, 0, 5, 0, 14, 0, 0, 4, 1,
+		0x12, 0x0A, 0x10, 0x02, 0, 3, 0, 1, 0x84, 0, 0, 0x50}
+	frame, err := Decode(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := frame.PDU
+	if pdu == nil || pdu.ROSCTR != Job || pdu.Reference != 5 || pdu.Function != FuncReadVar || len(pdu.Items) != 1 {
+		t.Fatalf("unexpected job: %+v", pdu)
+	}
+	if item := pdu.Items[0]; item.Area != 0x84 || item.DBNumber != 1 || item.Count != 3 || item.Address != 10*8 {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+	if dump := frame.String(); !strings.Contains(dump, "Item 1: DB1 BYTE 10.0 x3") {
+		t.Fatalf("unexpected dump:\n%s", dump)
+	}
+
+	// an item of odd length is padded unless it is the last one
+	response := []byte{3, 0, 0, 38, 2, 0xF0, 0x80, 0x32, 3, 0, 0, 0, 5, 0, 2, 0, 17, 0, 0, 4, 3,
+		0xFF, 4, 0, 24, 1, 2, 3, 0,
+		0x0A, 0, 0, 0,
+		0xFF, 3, 0, 1, 1}
+	if frame, err = Decode(response); err != nil {
+		t.Fatal(err)
+	}
+	items := frame.PDU.DataItems
+	if len(items) != 3 || !bytes.Equal(items[0].Data, []byte{1, 2, 3}) || items[1].ReturnCode != 0x0A ||
+		!bytes.Equal(items[2].Data, []byte{1}) {
+		t.Fatalf("unexpected data items: %+v", items)
+	}
+	if _, err = Decode(response[:len(response)-1]); err == nil {
+		t.Fatal("expected error on a truncated frame")
+	}
+	// an item with an empty address
+	empty := []byte{3, 0, 0, 0x1F, 2, 0xF0, 0x80, 0x32, 1, 0, 0, 0, 1, 0, 0x0E, 0, 0, 4, 1,
+		0x12, 0, 0x82, 0x41, 1, 0, 1, 0, 1, 0, 0xC7, 1}
+	if _, err = Decode(empty); err == nil {
+		t.Fatal("expected error on an empty item address")
+	}
+}
+
+func TestDecodeWriteVarNCK(t *testing.T) {
+	job := []byte{3, 0, 0, 35, 2, 0xF0, 0x80, 0x32, 1, 0, 0, 0, 1, 0, 12, 0, 6, 5, 1,
+		0x12, 0x08, 0x82, 0x41, 0, 2, 0, 1, 0x7F, 1,
+		0, 9, 0, 2, 0xAB, 0xCD}
+	frame, err := Decode(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := frame.PDU
+	if len(pdu.Items) != 1 || pdu.Items[0].NCK == nil || len(pdu.DataItems) != 1 {
+		t.Fatalf("unexpected job: %+v", pdu)
+	}
+	if nck := *pdu.Items[0].NCK; nck != (NCKAddress{Area: 2, Unit: 1, Column: 2, Line: 1, Module: 0x7F, LineCount: 1}) {
+		t.Fatalf("unexpected address: %+v", nck)
+	}
+	ack := []byte{3, 0, 0, 22, 2, 0xF0, 0x80, 0x32, 3, 0, 0, 0, 1, 0, 2, 0, 1, 0, 0, 5, 1, 0xFF}
+	if frame, err = Decode(ack); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame.PDU.ReturnCodes, []byte{0xFF}) {
+		t.Fatalf("unexpected return codes: %x", frame.PDU.ReturnCodes)
+	}
+}
+
+func TestDecodeSetupAndPIService(t *testing.T) {
+	cr := []byte{3, 0, 0, 22, 17, 0xE0, 0, 0, 0, 1, 0, 0xC0, 1, 10, 0xC1, 2, 1, 0, 0xC2, 2, 1, 2}
+	frame, err := Decode(cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.COTP.PDUType != COTPConnectionRequest || len(frame.COTP.Params) != 3 || frame.PDU != nil {
+		t.Fatalf("unexpected COTP: %+v", frame.COTP)
+	}
+	setup := []byte{3, 0, 0, 27, 2, 0xF0, 0x80, 0x32, 3, 0, 0, 0, 0, 0, 8, 0, 0, 0, 0,
+		0xF0, 0, 0, 1, 0, 1, 0x01, 0xE0}
+	if frame, err = Decode(setup); err != nil {
+		t.Fatal(err)
+	}
+	if s := frame.PDU.Setup; s == nil || s.PDULength != 480 || s.MaxAmqCaller != 1 {
+		t.Fatalf("unexpected setup: %+v", s)
+	}
+	coldStart := []byte{3, 0, 0, 39, 2, 240, 128, 50, 1, 0, 0, 15, 0, 0, 22, 0, 0, 40, 0, 0,
+		0, 0, 0, 0, 253, 0, 2, 67, 32, 9, 80, 95, 80, 82, 79, 71, 82, 65, 77}
+	if frame, err = Decode(coldStart); err != nil {
+		t.Fatal(err)
+	}
+	if pi := frame.PDU.PI; pi == nil || pi.Name != "P_PROGRAM" || string(pi.Params) != "C " {
+		t.Fatalf("unexpected PI service: %+v", pi)
+	}
+}
+
+func TestDecodeUserData(t *testing.T) {
+	// response to read SZL 0x0011 with two records of 4 bytes
+	szl := []byte{3, 0, 0, 49, 2, 0xF0, 0x80, 0x32, 7, 0, 0, 0, 2, 0, 12, 0, 20,
+		0, 1, 0x12, 8, 0x12, 0x84, 1, 0, 0, 0, 0, 0,
+		0xFF, 9, 0, 16, 0, 0x11, 0, 0, 0, 4, 0, 2, 1, 2, 3, 4, 5, 6, 7, 8}
+	frame, err := Decode(szl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := frame.PDU.UserData
+	if u == nil || u.Type != UserDataResponse || u.Group != GroupCPU || u.SZL == nil || u.SZL.ID != 0x11 {
+		t.Fatalf("unexpected userdata: %+v", u)
+	}
+	if len(u.SZL.Records) != 2 || !bytes.Equal(u.SZL.Records[1], []byte{5, 6, 7, 8}) {
+		t.Fatalf("unexpected records: %x", u.SZL.Records)
+	}
+	blockInfo := []byte{3, 0, 0, 37, 2, 0xF0, 0x80, 0x32, 7, 0, 0, 0, 3, 0, 8, 0, 12,
+		0, 1, 0x12, 4, 0x11, 0x43, 3, 0,
+		0xFF, 9, 0, 8, 0x30, 0x41, '0', '0', '0', '4', '2', 'A'}
+	if frame, err = Decode(blockInfo); err != nil {
+		t.Fatal(err)
+	}
+	if b := frame.PDU.UserData.Block; b == nil || b.Type != 0x41 || b.Number != 42 {
+		t.Fatalf("unexpected block: %+v", b)
+	}
+	if dump := Dump(blockInfo).String(); !strings.Contains(dump, "Get block info") {
+		t.Fatalf("unexpected dump:\n%s", dump)
+	}
+}
+
+func TestDecodeWriteVarNCKItems(t *testing.T) {
+	// items of 3 and 2 octets, without fill byte
+	job := []byte{3, 0, 0, 52, 2, 0xF0, 0x80, 0x32, 1, 0, 0, 0, 1, 0, 22, 0, 13, 5, 2,
+		0x12, 0x08, 0x82, 0x41, 0, 2, 0, 1, 0x7F, 1,
+		0x12, 0x08, 0x82, 0x41, 0, 3, 0, 1, 0x7F, 1,
+		0, 9, 0, 3, 1, 2, 3,
+		0, 9, 0, 2, 4, 5}
+	frame, err := Decode(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := frame.PDU.DataItems
+	if len(items) != 2 || !bytes.Equal(items[0].Data, []byte{1, 2, 3}) || !bytes.Equal(items[1].Data, []byte{4, 5}) {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+}
@@ -4,11 +4,17 @@ package gos7
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 )
 
 func (mb *client) SetSessionPassword(password string) error {
+	return mb.SetSessionPasswordContext(context.Background(), password)
+}
+
+// SetSessionPasswordContext is the context-aware variant of SetSessionPassword
+func (mb *client) SetSessionPasswordContext(ctx context.Context, password string) error {
 	pwd := []byte{0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20}
 	// Encodes the Password
 
@@ -27,13 +33,18 @@ func (mb *client) SetSessionPassword(password string) error {
 
 	request := NewProtocolDataUnit(requestData)
 	//send
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err == nil {
 		err = verifySecurityResponse(response.Data)
 	}
 	return err
 }
 func (mb *client) ClearSessionPassword() error {
+	return mb.ClearSessionPasswordContext(context.Background())
+}
+
+// ClearSessionPasswordContext is the context-aware variant of ClearSessionPassword
+func (mb *client) ClearSessionPasswordContext(ctx context.Context) error {
 	requestData := make([]byte, len(s7ClearPWDTelegram))
 	//copy from telegram base
 	copy(requestData, s7ClearPWDTelegram)
@@ -41,7 +52,7 @@ func (mb *client) ClearSessionPassword() error {
 		Data: requestData,
 	}
 	//send
-	response, err := mb.send(&request)
+	response, err := mb.send(ctx, &request)
 	if err == nil {
 		err = verifySecurityResponse(response.Data)
 	}
@@ -50,8 +61,13 @@ func (mb *client) ClearSessionPassword() error {
 }
 
 func (mb *client) GetProtection() (protection S7Protection, err error) {
+	return mb.GetProtectionContext(context.Background())
+}
+
+// GetProtectionContext is the context-aware variant of GetProtection
+func (mb *client) GetProtectionContext(ctx context.Context) (protection S7Protection, err error) {
 
-	szl, _, err := mb.readSzl(0x0232, 0x0004)
+	szl, _, err := mb.readSzl(ctx, 0x0232, 0x0004)
 	if err == nil {
 		protection.schSchal = uint(binary.BigEndian.Uint16(szl.Data[2:]))
 		protection.schPar = uint(binary.BigEndian.Uint16(szl.Data[4:]))
@@ -0,0 +1,406 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"math/bits"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	serverTPDUSize   = 1024 // largest TPDU size granted
+	serverMaxAmq     = 8    // most parallel jobs granted
+	serverAreaSize   = 1024 // default size of the input and output areas
+	serverMerkerSize = 4096 // default size of the merker area
+	serverCounters   = 512  // default number of counters and timers
+)
+
+var errServerClosed = errors.New("s7: server closed")
+
+// Server is an S7 server emulating a CPU in memory. It answers the ISO
+// connection request, the communication setup, read/write var jobs, start/stop
+// and the SZL, block and clock functions of the user data protocol, so that
+// code built on Client can be tested without hardware. With OnRead and OnWrite
+// it is the host side of PUT/GET instructions of S7-300/400/1200/1500 programs.
+type Server struct {
+	// OnRead serves the items read by clients instead of the memory of the
+	// server. It returns size bytes from byte start of the area (and DB db) or a
+	// return code other than ItemOK. Bits are read as their byte, counters and
+	// timers take 2 bytes each. It may be called from several connections at once.
+	OnRead func(area, db, start, size int) ([]byte, byte)
+	// OnWrite stores the items written by clients instead of the memory of the
+	// server and returns ItemOK or the return code of the item. A bit write has
+	// the bit 0-7 of byte start and data of one byte, 0 or 1. Other writes have
+	// bit -1.
+	OnWrite func(area, db, start, bit int, data []byte) byte
+	// PDULength is the largest PDU length granted, 0 grants up to 480 bytes
+	PDULength int
+	// CPUInfo is reported by GetCPUInfo (SZL 0x001C)
+	CPUInfo S7CpuInfo
+	// OrderCode is reported by SZL 0x0011
+	OrderCode S7OrderCode
+	// Logger logs connections and protocol errors, nil disables logging
+	Logger *log.Logger
+	// Log is the structured logger, it takes precedence over Logger
+	Log *slog.Logger
+
+	plainLog plainLog // Logger adapted to slog
+
+	mu     sync.Mutex
+	status int
+	areas  map[int][]byte
+	dbs    map[int][]byte
+	blocks map[int]map[int]S7BlockInfo
+	clock  time.Duration // offset of the CPU clock to the system clock
+
+	cmu     sync.Mutex
+	closers map[io.Closer]struct{} // listeners and connections
+	ln      net.Listener
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// serverSession is the state of one client connection.
+type serverSession struct {
+	pduLength int
+	tpduSize  int
+	// parts of a block list not sent yet, by sequence number
+	blockLists map[byte][]byte
+	nextSeq    byte
+}
+
+// NewServer allocates a new Server in RUN mode with empty input, output, merker,
+// counter and timer areas and no DBs.
+func NewServer() *Server {
+	return &Server{
+		status: s7CpuStatusRun,
+		areas: map[int][]byte{
+			s7areape: make([]byte, serverAreaSize),
+			s7areapa: make([]byte, serverAreaSize),
+			s7areamk: make([]byte, serverMerkerSize),
+			s7areact: make([]byte, serverCounters*2),
+			s7areatm: make([]byte, serverCounters*2),
+		},
+		dbs:     make(map[int][]byte),
+		blocks:  make(map[int]map[int]S7BlockInfo),
+		closers: make(map[io.Closer]struct{}),
+	}
+}
+
+// SetDB creates or replaces DB number with a copy of data.
+func (s *Server) SetDB(number int, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dbs[number] = append([]byte(nil), data...)
+}
+
+// DB returns a copy of DB number, false if it does not exist.
+func (s *Server) DB(number int) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, ok := s.dbs[number]
+	return append([]byte(nil), db...), ok
+}
+
+// SetArea replaces the memory of an area (inputs, outputs, merkers, counters or
+// timers, as the area codes of the client) with a copy of data. Counters and
+// timers take 2 bytes each.
+func (s *Server) SetArea(area int, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.areas[area] = append([]byte(nil), data...)
+}
+
+// Area returns a copy of the memory of an area.
+func (s *Server) Area(area int) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.areas[area]...)
+}
+
+// SetBlockInfo adds a block to the block list, blockType is one of the types of
+// GetAgBlockInfo. Dates are given as "02.01.2006" like they are returned. The
+// MC7Size of a DB defaults to the size of its data.
+func (s *Server) SetBlockInfo(blockType, number int, info S7BlockInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blocks[blockType] == nil {
+		s.blocks[blockType] = make(map[int]S7BlockInfo)
+	}
+	s.blocks[blockType][number] = info
+}
+
+// SetStatus sets the CPU status reported by PLCGetStatus, 8 = RUN or 4 = STOP.
+func (s *Server) SetStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// Status returns the CPU status.
+func (s *Server) Status() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Listen listens on the TCP address and serves the connections in the
+// background until Close. Port 102 is used if address has none, ":0" picks a
+// free port which is returned by Addr.
+func (s *Server) Listen(address string) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(isoTCP))
+	}
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.cmu.Lock()
+	s.ln = ln
+	s.cmu.Unlock()
+	go s.Serve(ln)
+	return nil
+}
+
+// Addr returns the address of the listener started by Listen, nil if there is none.
+func (s *Server) Addr() net.Addr {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// Serve accepts connections on ln and serves each of them in its own goroutine
+// until ln fails or the server is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	if !s.track(ln) {
+		ln.Close()
+		return errServerClosed
+	}
+	defer s.untrack(ln)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return errServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves one connection until the client disconnects.
+func (s *Server) ServeConn(conn net.Conn) {
+	if !s.track(conn) {
+		conn.Close()
+		return
+	}
+	defer s.untrack(conn)
+	defer conn.Close()
+	s.log(slog.LevelInfo, "s7: client connected", "client", conn.RemoteAddr())
+	session := &serverSession{}
+	for {
+		maxLength := session.pduLength
+		if maxLength == 0 {
+			maxLength = s.pduLength()
+		}
+		request, err := receive(conn, maxLength, session.tpduSize)
+		if err != nil {
+			if err != io.EOF && !s.isClosed() {
+				s.log(slog.LevelWarn, "s7: client connection failed", "client", conn.RemoteAddr(), "error", err)
+			}
+			return
+		}
+		response := s.handle(session, request)
+		if response == nil {
+			s.log(slog.LevelWarn, "s7: dropping the connection on an unexpected request", "client", conn.RemoteAddr(),
+				"request", frameDump(request))
+			return
+		}
+		if err = write(conn, response, session.tpduSize); err != nil {
+			return
+		}
+	}
+}
+
+// Close stops the listeners, closes all connections and waits until they are done.
+func (s *Server) Close() error {
+	s.cmu.Lock()
+	s.closed = true
+	for c := range s.closers {
+		c.Close()
+	}
+	s.cmu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) track(c io.Closer) bool {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.closers[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(c io.Closer) {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	delete(s.closers, c)
+	s.wg.Done()
+}
+
+func (s *Server) isClosed() bool {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	return s.closed
+}
+
+func (s *Server) pduLength() int {
+	if s.PDULength > 0 {
+		return s.PDULength
+	}
+	return pduSizeRequested
+}
+
+// handle returns the response to a request, nil if the connection must be dropped.
+func (s *Server) handle(session *serverSession, request []byte) []byte {
+	if len(request) < isoHSize {
+		return nil
+	}
+	switch request[5] {
+	case cotpCR:
+		return s.connectionConfirm(session, request)
+	case cotpDT:
+	default:
+		return nil
+	}
+	if len(request) < 17 || request[7] != 0x32 {
+		return nil
+	}
+	switch request[8] {
+	case 1: // job
+		if len(request) < 19 {
+			return nil
+		}
+		if request[17] == 0xF0 {
+			return s.setupCommunication(session, request)
+		}
+		if session.pduLength == 0 {
+			// the communication must be set up first
+			return nil
+		}
+		switch request[17] {
+		case s7WriteReadFunction:
+			return s.readVar(session, request)
+		case s7WriteVarFunction:
+			return s.writeVar(request)
+		case pduStart:
+			return s.start(request)
+		case pduStop:
+			return s.stop(request)
+		}
+		return ackData(request, code7FunNotAvailable, nil, nil)
+	case 7: // user data
+		if session.pduLength == 0 || len(request) < 25 {
+			return nil
+		}
+		return s.userData(session, request)
+	}
+	return nil
+}
+
+// connectionConfirm accepts an ISO connection request with any TSAPs. The TPDU
+// size is limited to serverTPDUSize, the TSAPs are echoed.
+func (s *Server) connectionConfirm(session *serverSession, request []byte) []byte {
+	if len(request) < 11 {
+		return nil
+	}
+	size := tpduSizeParam(request)
+	if size == 0 {
+		size = 128 // default of class 0
+	}
+	size = min(size, serverTPDUSize)
+	session.tpduSize = size
+	response := []byte{
+		3, 0, 0, 0,
+		0, cotpCC, request[8], request[9], // dst ref is the src ref of the CR
+		0, 1, 0, // src ref, class 0
+		cotpTPDUSizeCode, 1, byte(bits.TrailingZeros(uint(size))),
+	}
+	end := min(len(request), tpktHSize+1+int(request[4]))
+	for i := tpktHSize + 7; i+2 <= end; {
+		code, length := request[i], int(request[i+1])
+		if i+2+length > end {
+			break
+		}
+		if code != cotpTPDUSizeCode {
+			response = append(response, request[i:i+2+length]...)
+		}
+		i += 2 + length
+	}
+	response[4] = byte(len(response) - 5)
+	binary.BigEndian.PutUint16(response[2:], uint16(len(response)))
+	return response
+}
+
+// setupCommunication grants the requested PDU length and parallel jobs up to the server limits.
+func (s *Server) setupCommunication(session *serverSession, request []byte) []byte {
+	if len(request) < 25 {
+		return nil
+	}
+	amqCaller := min(max(int(binary.BigEndian.Uint16(request[19:])), 1), serverMaxAmq)
+	amqCallee := min(max(int(binary.BigEndian.Uint16(request[21:])), 1), serverMaxAmq)
+	pduLength := min(int(binary.BigEndian.Uint16(request[23:])), s.pduLength())
+	if pduLength < minPduSize {
+		return nil
+	}
+	session.pduLength = pduLength
+	params := []byte{0xF0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(params[2:], uint16(amqCaller))
+	binary.BigEndian.PutUint16(params[4:], uint16(amqCallee))
+	binary.BigEndian.PutUint16(params[6:], uint16(pduLength))
+	return ackData(request, 0, params, nil)
+}
+
+// ackData returns the ack data response to a job with the error code, parameters and data.
+func ackData(request []byte, errorCode int, params, data []byte) []byte {
+	response := make([]byte, 19, 19+len(params)+len(data))
+	copy(response, tpktISOTelegram)
+	response[7] = 0x32
+	response[8] = 3 // ack data
+	copy(response[11:13], request[11:13])
+	binary.BigEndian.PutUint16(response[13:], uint16(len(params)))
+	binary.BigEndian.PutUint16(response[15:], uint16(len(data)))
+	binary.BigEndian.PutUint16(response[17:], uint16(errorCode))
+	response = append(response, params...)
+	response = append(response, data...)
+	binary.BigEndian.PutUint16(response[2:], uint16(len(response)))
+	return response
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[T any](m map[int]T) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,35 @@
+package gos7_test
+
+import (
+	"testing"
+
+	"github.com/punk-one/gos7"
+	"github.com/punk-one/gos7/test"
+)
+
+// TestServerHardwareScenarios runs the tests written for the PLC of the test
+// package against a server set up like it.
+func TestServerHardwareScenarios(t *testing.T) {
+	const (
+		blockOB = 0x38
+		blockDB = 0x41
+		blockFB = 0x45
+	)
+	server := gos7.NewServer()
+	server.CPUInfo.SerialNumber = "0118701484"
+	for i := 0; i < 10; i++ {
+		server.SetBlockInfo(blockOB, 1+i, gos7.S7BlockInfo{})
+	}
+	for i := 0; i < 110; i++ {
+		server.SetDB(1+i, make([]byte, 16))
+	}
+	for _, db := range []int{2710, 2810, 2910} {
+		server.SetDB(db, make([]byte, 16))
+	}
+	server.SetBlockInfo(blockDB, 2710, gos7.S7BlockInfo{CodeDate: "22.01.2018"})
+	for i := 0; i < 81; i++ {
+		server.SetBlockInfo(blockFB, 1+i, gos7.S7BlockInfo{})
+	}
+	_, client := gos7.NewTestServerClient(t, server)
+	test.ClientTestAll(t, client)
+}
@@ -0,0 +1,160 @@
+package gos7
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestServerClient starts server on localhost and connects a client to it,
+// configure sets up the handler before it connects. Both are closed when the
+// test ends.
+func newTestServerClient(t *testing.T, server *Server, configure ...func(*TCPClientHandler)) (*TCPClientHandler, Client) {
+	t.Helper()
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	handler := NewTCPClientHandler(server.Addr().String(), 0, 2)
+	for _, c := range configure {
+		c(handler)
+	}
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handler.Close() })
+	return handler, NewClient(handler)
+}
+
+func TestServer(t *testing.T) {
+	server := NewServer()
+	server.PDULength = 240
+	server.CPUInfo.ModuleTypeName = "CPU 315-2 PN/DP"
+	for i := 1; i <= 80; i++ {
+		server.SetDB(i, make([]byte, 32))
+	}
+	server.SetBlockInfo(blockFC, 10, S7BlockInfo{Author: "gos7"})
+	handler, client := newTestServerClient(t, server)
+	if params := handler.SessionParams(); params.PDULength != 240 {
+		t.Fatalf("unexpected session: %+v", params)
+	}
+
+	if err := client.AGWriteDB(3, 4, 2, []byte{0x12, 0x34}); err != nil {
+		t.Fatal(err)
+	}
+	if db, _ := server.DB(3); !bytes.Equal(db[4:6], []byte{0x12, 0x34}) {
+		t.Fatalf("unexpected DB3: %x", db)
+	}
+	// larger than a PDU, the client splits the job
+	buf := make([]byte, 600)
+	buf[599] = 0x56
+	if err := client.AGWriteMB(0, 600, buf); err != nil {
+		t.Fatal(err)
+	}
+	read := make([]byte, 600)
+	if err := client.AGReadMB(0, 600, read); err != nil || !bytes.Equal(read, buf) {
+		t.Fatalf("unexpected merkers: %v", err)
+	}
+	items := []S7DataItem{
+		{Area: s7areadb, WordLen: s7wlbyte, DBNumber: 3, Start: 4, Amount: 2, Data: make([]byte, 2)},
+		{Area: s7areadb, WordLen: s7wlbyte, DBNumber: 999, Start: 0, Amount: 2, Data: make([]byte, 2)},
+		{Area: s7areamk, WordLen: s7wlbit, Start: 599, Bit: 1, Amount: 1, Data: make([]byte, 1)},
+	}
+	if err := client.AGReadMulti(items, len(items)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(items[0].Data, []byte{0x12, 0x34}) || items[1].Error == "" || items[2].Data[0] != 1 {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+	if err := client.AGReadDB(3, 30, 4, make([]byte, 4)); err == nil {
+		t.Fatal("read beyond the DB succeeded")
+	}
+
+	// the DB list does not fit into one PDU
+	list, err := client.PGListBlocks()
+	if err != nil || len(list.DBList) != 80 || list.DBList[79] != 80 || len(list.FCList) != 1 {
+		t.Fatalf("unexpected block list: %+v %v", list, err)
+	}
+	info, err := client.GetAgBlockInfo(blockDB, 3)
+	if err != nil || info.MC7Size != 32 || info.BlkNumber != 3 {
+		t.Fatalf("unexpected block info: %+v %v", info, err)
+	}
+	if _, err = client.GetAgBlockInfo(blockDB, 81); err == nil {
+		t.Fatal("info of a missing block succeeded")
+	}
+	if cpu, err := client.GetCPUInfo(); err != nil || cpu.ModuleTypeName != "CPU 315-2 PN/DP" {
+		t.Fatalf("unexpected CPU info: %+v %v", cpu, err)
+	}
+
+	if err = client.PLCStop(); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := client.PLCGetStatus(); err != nil || status != s7CpuStatusStop {
+		t.Fatalf("unexpected status: %d %v", status, err)
+	}
+	if err = client.PLCHotStart(); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.PLCHotStart(); err == nil {
+		t.Fatal("start of a running CPU succeeded")
+	}
+	if now, err := client.PGClockWrite(); err != nil || time.Since(now) > time.Minute {
+		t.Fatalf("unexpected clock: %v %v", now, err)
+	}
+}
+
+func TestServerHandlers(t *testing.T) {
+	var mu sync.Mutex
+	db1 := make([]byte, 8)
+	server := NewServer()
+	server.OnRead = func(area, db, start, size int) ([]byte, byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		if area != AreaDB || db != 1 {
+			return nil, ItemAccessDenied
+		}
+		if start+size > len(db1) {
+			return nil, ItemAddressOutOfRange
+		}
+		return append([]byte(nil), db1[start:start+size]...), ItemOK
+	}
+	server.OnWrite = func(area, db, start, bit int, data []byte) byte {
+		mu.Lock()
+		defer mu.Unlock()
+		if area != AreaDB || db != 1 {
+			return ItemAccessDenied
+		}
+		if bit >= 0 {
+			db1[start] = setBit(db1[start], uint(bit), data[0] != 0)
+			return ItemOK
+		}
+		copy(db1[start:], data)
+		return ItemOK
+	}
+	_, client := newTestServerClient(t, server)
+
+	items := []S7DataItem{
+		{Area: AreaDB, WordLen: s7wlbyte, DBNumber: 1, Start: 2, Amount: 2, Data: []byte{0xAB, 0xCD}},
+		{Area: AreaDB, WordLen: s7wlbit, DBNumber: 1, Start: 5*8 + 3, Amount: 1, Data: []byte{1}}, // bit address
+		{Area: AreaMK, WordLen: s7wlbyte, Start: 0, Amount: 1, Data: []byte{1}},
+	}
+	if err := client.AGWriteMulti(items, len(items)); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if !bytes.Equal(db1, []byte{0, 0, 0xAB, 0xCD, 0, 0x08, 0, 0}) {
+		t.Fatalf("unexpected DB1: %x", db1)
+	}
+	mu.Unlock()
+	if items[2].Error == "" {
+		t.Fatal("write to a denied area succeeded")
+	}
+	buf := make([]byte, 2)
+	if err := client.AGReadDB(1, 2, 2, buf); err != nil || !bytes.Equal(buf, []byte{0xAB, 0xCD}) {
+		t.Fatalf("unexpected read: %x %v", buf, err)
+	}
+	if err := client.AGReadDB(1, 6, 4, make([]byte, 4)); err == nil {
+		t.Fatal("read beyond DB1 succeeded")
+	}
+}
@@ -0,0 +1,267 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+)
+
+// Item return codes of read/write var responses
+const (
+	ItemOK                   = 0xFF
+	ItemHardwareFault        = 0x01
+	ItemAccessDenied         = 0x03
+	ItemAddressOutOfRange    = code7AddressOutOfRange
+	ItemInvalidTransportSize = code7InvalidTransportSize
+	ItemDataSizeMismatch     = code7WriteDataSizeMismatch
+	ItemNotAvailable         = code7ResItemNotAvailable
+)
+
+const serverSyntaxS7 = 0x10 // syntax ID of an S7ANY item address
+
+// serverItem is the address of one item of a read/write var job.
+type serverItem struct {
+	syntax   byte
+	wordLen  int
+	count    int
+	dbNumber int
+	area     int
+	address  int // bit address, element number for bits, counters and timers
+}
+
+// parseItems returns the item addresses of a read/write var job.
+func parseItems(request []byte) ([]serverItem, bool) {
+	count := int(request[18])
+	items := make([]serverItem, 0, count)
+	offset := 19
+	for i := 0; i < count; i++ {
+		if offset+2 > len(request) || offset+2+int(request[offset+1]) > len(request) {
+			return nil, false
+		}
+		p := request[offset:]
+		item := serverItem{syntax: p[2]}
+		if item.syntax == serverSyntaxS7 && p[1] >= 10 {
+			item.wordLen = int(p[3])
+			item.count = int(binary.BigEndian.Uint16(p[4:]))
+			item.dbNumber = int(binary.BigEndian.Uint16(p[6:]))
+			item.area = int(p[8])
+			item.address = int(p[9])<<16 | int(p[10])<<8 | int(p[11])
+		}
+		items = append(items, item)
+		offset += 2 + int(p[1])
+	}
+	return items, true
+}
+
+// bytes returns the range of bytes the item covers, or its return code.
+func (item serverItem) bytes() (start, size int, code byte) {
+	if item.syntax != serverSyntaxS7 {
+		return 0, 0, ItemNotAvailable
+	}
+	switch item.area {
+	case AreaPE, AreaPA, AreaMK, AreaDB, AreaCT, AreaTM:
+	default:
+		return 0, 0, ItemNotAvailable
+	}
+	switch item.wordLen {
+	case s7wlbit:
+		if item.count != 1 {
+			return 0, 0, ItemInvalidTransportSize
+		}
+		return item.address >> 3, 1, ItemOK
+	case s7wlcounter, s7wltimer:
+		return item.address * 2, item.count * 2, ItemOK
+	}
+	wordSize := dataSizeByte(item.wordLen)
+	if wordSize == 0 {
+		return 0, 0, ItemInvalidTransportSize
+	}
+	return item.address >> 3, item.count * wordSize, ItemOK
+}
+
+// read returns the value of an item or its return code.
+func (s *Server) read(item serverItem) ([]byte, byte) {
+	start, size, code := item.bytes()
+	if code != ItemOK {
+		return nil, code
+	}
+	value, code := s.readBytes(item.area, item.dbNumber, start, size)
+	if code != ItemOK {
+		return nil, code
+	}
+	if item.wordLen == s7wlbit {
+		return []byte{value[0] >> uint(item.address&7) & 1}, code
+	}
+	return value, code
+}
+
+// write stores the value of an item and returns its return code.
+func (s *Server) write(item serverItem, value []byte) byte {
+	start, size, code := item.bytes()
+	if code != ItemOK {
+		return code
+	}
+	if len(value) != size {
+		return ItemDataSizeMismatch
+	}
+	if item.wordLen == s7wlbit {
+		return s.writeBit(item.area, item.dbNumber, start, item.address&7, value[0]&1)
+	}
+	return s.writeBytes(item.area, item.dbNumber, start, value)
+}
+
+// readBytes reads from OnRead or the memory of the server.
+func (s *Server) readBytes(area, db, start, size int) ([]byte, byte) {
+	if s.OnRead != nil {
+		value, code := s.OnRead(area, db, start, size)
+		if code == ItemOK && len(value) != size {
+			return nil, ItemAddressOutOfRange
+		}
+		return value, code
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mem, code := s.memory(area, db, start, size)
+	return append([]byte(nil), mem...), code
+}
+
+// writeBytes writes to OnWrite or the memory of the server.
+func (s *Server) writeBytes(area, db, start int, value []byte) byte {
+	if s.OnWrite != nil {
+		return s.OnWrite(area, db, start, -1, value)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mem, code := s.memory(area, db, start, len(value))
+	copy(mem, value)
+	return code
+}
+
+// writeBit sets a bit to value, 0 or 1, in OnWrite or the memory of the server.
+func (s *Server) writeBit(area, db, start, bit int, value byte) byte {
+	if s.OnWrite != nil {
+		return s.OnWrite(area, db, start, bit, []byte{value})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mem, code := s.memory(area, db, start, 1)
+	if code == ItemOK {
+		mem[0] = setBit(mem[0], uint(bit), value != 0)
+	}
+	return code
+}
+
+// memory returns size bytes at start of an area in the memory of the server,
+// or the item return code. Caller must hold the mutex.
+func (s *Server) memory(area, db, start, size int) ([]byte, byte) {
+	mem, ok := s.areas[area]
+	if area == AreaDB {
+		mem, ok = s.dbs[db]
+	}
+	if !ok {
+		return nil, ItemNotAvailable
+	}
+	if start+size > len(mem) {
+		return nil, ItemAddressOutOfRange
+	}
+	return mem[start : start+size], ItemOK
+}
+
+func setBit(b byte, bit uint, set bool) byte {
+	if set {
+		return b | 1<<bit
+	}
+	return b &^ (1 << bit)
+}
+
+// readVar answers a read var job, every item carries its own return code.
+func (s *Server) readVar(session *serverSession, request []byte) []byte {
+	items, ok := parseItems(request)
+	if !ok {
+		return ackData(request, code7InvalidValue, nil, nil)
+	}
+	var data []byte
+	for i, item := range items {
+		value, code := s.read(item)
+		if code != ItemOK {
+			data = append(data, code, 0, 0, 0)
+			continue
+		}
+		var ts byte
+		length := len(value)
+		switch item.wordLen {
+		case s7wlbit:
+			ts = tsResBit
+		case s7wlcounter, s7wltimer:
+			ts = tsResOctet
+		case s7wlreal:
+			ts = tsResReal
+		default:
+			ts, length = tsResByte, length*8 // length in bits
+		}
+		data = append(data, ItemOK, ts, byte(length>>8), byte(length))
+		data = append(data, value...)
+		if len(value)%2 != 0 && i < len(items)-1 {
+			data = append(data, 0) // fill byte
+		}
+	}
+	response := ackData(request, 0, []byte{s7WriteReadFunction, byte(len(items))}, data)
+	if len(response)-isoHSize > session.pduLength {
+		return ackData(request, code7DataOverPDU, nil, nil)
+	}
+	return response
+}
+
+// writeVar answers a write var job with one return code per item.
+func (s *Server) writeVar(request []byte) []byte {
+	items, ok := parseItems(request)
+	if !ok {
+		return ackData(request, code7InvalidValue, nil, nil)
+	}
+	offset := 17 + int(binary.BigEndian.Uint16(request[13:]))
+	codes := make([]byte, len(items))
+	for i, item := range items {
+		if offset+4 > len(request) {
+			codes[i] = code7WriteDataSizeMismatch
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(request[offset+2:]))
+		switch request[offset+1] {
+		case tsResBit:
+			length = (length + 7) / 8
+		case tsResByte, tsResInt, 6: // length in bits
+			length /= 8
+		}
+		if offset+4+length > len(request) {
+			codes[i] = code7WriteDataSizeMismatch
+			offset = len(request)
+			continue
+		}
+		codes[i] = s.write(item, request[offset+4:offset+4+length])
+		offset += 4 + length + length%2
+	}
+	return ackData(request, 0, []byte{s7WriteVarFunction, byte(len(items))}, codes)
+}
+
+// start answers a PI service start request.
+func (s *Server) start(request []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == s7CpuStatusRun {
+		return ackData(request, 0, []byte{pduStart, pduAlreadyStarted}, nil)
+	}
+	s.status = s7CpuStatusRun
+	return ackData(request, 0, []byte{pduStart}, nil)
+}
+
+// stop answers a PI service stop request.
+func (s *Server) stop(request []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == s7CpuStatusStop {
+		return ackData(request, 0, []byte{pduStop, pduAlreadyStopped}, nil)
+	}
+	s.status = s7CpuStatusStop
+	return ackData(request, 0, []byte{pduStop}, nil)
+}
@@ -0,0 +1,339 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"strconv"
+	"time"
+)
+
+// User data function groups
+const (
+	udGroupBlock = 3
+	udGroupSZL   = 4
+	udGroupClock = 7
+)
+
+// userData answers a user data request.
+func (s *Server) userData(session *serverSession, request []byte) []byte {
+	group, sub := request[22]&0x0F, request[23]
+	switch {
+	case group == udGroupSZL && sub == 1:
+		return s.readSZL(request)
+	case group == udGroupBlock && sub == 2:
+		return s.blockList(session, request)
+	case group == udGroupBlock && sub == 3:
+		return s.blockInfo(request)
+	case group == udGroupClock && sub == 1:
+		return s.readClock(request)
+	case group == udGroupClock && sub == 2:
+		return s.setClock(request)
+	}
+	return userDataResponse(request, 0, false, code7FunNotAvailable, nil)
+}
+
+// userDataPayload returns the data of a user data request after the return code,
+// transport size and length.
+func userDataPayload(request []byte) []byte {
+	offset := 17 + int(binary.BigEndian.Uint16(request[13:]))
+	if offset+4 > len(request) {
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(request[offset+2:]))
+	return request[offset+4 : min(offset+4+length, len(request))]
+}
+
+// userDataResponse returns the response to a user data request. It is part seq
+// of a multi-part answer if more parts follow.
+func userDataResponse(request []byte, seq byte, more bool, errorCode int, payload []byte) []byte {
+	response := make([]byte, 17, 33+len(payload))
+	copy(response, tpktISOTelegram)
+	response[7] = 0x32
+	response[8] = 7 // user data
+	copy(response[11:13], request[11:13])
+	params := []byte{
+		0, 1, 0x12, 8, 0x12,
+		0x80 | request[22]&0x0F, // response of the function group
+		request[23], seq,
+		0, 0, // data unit reference, last data unit
+		byte(errorCode >> 8), byte(errorCode),
+	}
+	if more {
+		params[9] = 1
+	}
+	data := []byte{0x0A, 0, 0, 0} // no data
+	if errorCode == 0 {
+		data = append([]byte{0xFF, tsResOctet, byte(len(payload) >> 8), byte(len(payload))}, payload...)
+	}
+	binary.BigEndian.PutUint16(response[13:], uint16(len(params)))
+	binary.BigEndian.PutUint16(response[15:], uint16(len(data)))
+	response = append(response, params...)
+	response = append(response, data...)
+	binary.BigEndian.PutUint16(response[2:], uint16(len(response)))
+	return response
+}
+
+// readSZL answers the read of an SZL partial list.
+func (s *Server) readSZL(request []byte) []byte {
+	payload := userDataPayload(request)
+	if len(payload) < 4 {
+		return userDataResponse(request, 0, false, code7InvalidValue, nil)
+	}
+	id := binary.BigEndian.Uint16(payload)
+	index := binary.BigEndian.Uint16(payload[2:])
+	recordLen, records := s.szlRecords(id)
+	if records == nil {
+		return userDataResponse(request, 0, false, code7ResItemNotAvailable1, nil)
+	}
+	list := make([]byte, 8, 8+len(records))
+	binary.BigEndian.PutUint16(list, id)
+	binary.BigEndian.PutUint16(list[2:], index)
+	binary.BigEndian.PutUint16(list[4:], uint16(recordLen))
+	binary.BigEndian.PutUint16(list[6:], uint16(len(records)/recordLen))
+	return userDataResponse(request, 1, false, 0, append(list, records...))
+}
+
+// szlRecords returns the records of an SZL partial list and their length, nil if the list is unknown.
+func (s *Server) szlRecords(id uint16) (recordLen int, records []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch id {
+	case 0x001C: // component identification
+		recordLen = 34
+		for _, r := range []struct {
+			index uint16
+			text  string
+			size  int
+		}{
+			{1, s.CPUInfo.ASName, 24},
+			{2, s.CPUInfo.ModuleName, 24},
+			{3, "", 32}, // plant designation
+			{4, s.CPUInfo.Copyright, 26},
+			{5, s.CPUInfo.SerialNumber, 24},
+			{7, s.CPUInfo.ModuleTypeName, 32},
+		} {
+			record := make([]byte, recordLen)
+			binary.BigEndian.PutUint16(record, r.index)
+			copy(record[2:], padRight(r.text, r.size))
+			records = append(records, record...)
+		}
+	case 0x0011: // module identification
+		recordLen = 28
+		module := make([]byte, recordLen)
+		binary.BigEndian.PutUint16(module, 1)
+		copy(module[2:], padRight(s.OrderCode.Code, 20))
+		firmware := make([]byte, recordLen)
+		binary.BigEndian.PutUint16(firmware, 7)
+		copy(firmware[2:], padRight("", 20))
+		firmware[24], firmware[25] = 'V', s.OrderCode.V1
+		firmware[26], firmware[27] = s.OrderCode.V2, s.OrderCode.V3
+		records = append(module, firmware...)
+	case 0x0131: // communication capability parameters
+		recordLen = 40
+		records = make([]byte, recordLen)
+		binary.BigEndian.PutUint16(records, 1)
+		binary.BigEndian.PutUint16(records[2:], uint16(s.pduLength()))
+		binary.BigEndian.PutUint16(records[4:], 32)       // max connections
+		binary.BigEndian.PutUint32(records[6:], 187500)   // MPI rate
+		binary.BigEndian.PutUint32(records[10:], 1500000) // communication bus rate
+	case 0x0232: // protection level
+		recordLen = 40
+		records = make([]byte, recordLen)
+		binary.BigEndian.PutUint16(records, 4)
+		binary.BigEndian.PutUint16(records[2:], 1) // mode selector protection
+		binary.BigEndian.PutUint16(records[6:], 1) // valid protection level
+		switch s.status {
+		case s7CpuStatusRun:
+			binary.BigEndian.PutUint16(records[8:], 1) // mode selector in RUN
+		default:
+			binary.BigEndian.PutUint16(records[8:], 3) // mode selector in STOP
+		}
+	case 0x0424: // current mode
+		recordLen = 20
+		records = make([]byte, recordLen)
+		binary.BigEndian.PutUint16(records, 0x5104) // event: mode transition
+		records[2] = 0xFF
+		records[3] = byte(s.status)
+	}
+	return
+}
+
+// blockList answers a block list request, lists which do not fit into one PDU
+// are sent in several parts.
+func (s *Server) blockList(session *serverSession, request []byte) []byte {
+	if request[20] == 8 {
+		// continuation of a previous list
+		seq := request[24]
+		rest, ok := session.blockLists[seq]
+		if !ok {
+			return userDataResponse(request, seq, false, code7InvalidValue, nil)
+		}
+		delete(session.blockLists, seq)
+		return s.blockListPart(session, request, seq, rest)
+	}
+	payload := userDataPayload(request)
+	if len(payload) < 2 {
+		return userDataResponse(request, 0, false, code7InvalidValue, nil)
+	}
+	blockType := int(payload[1])
+	s.mu.Lock()
+	numbers := make(map[int]bool)
+	for number := range s.blocks[blockType] {
+		numbers[number] = true
+	}
+	if blockType == blockDB {
+		for number := range s.dbs {
+			numbers[number] = true
+		}
+	}
+	var list []byte
+	for _, number := range sortedKeys(numbers) {
+		info := s.blockInfoLocked(blockType, number)
+		list = append(list, byte(number>>8), byte(number), 0x22, byte(info.BlkLang))
+	}
+	s.mu.Unlock()
+	session.nextSeq++
+	if session.nextSeq == 0 {
+		session.nextSeq++
+	}
+	return s.blockListPart(session, request, session.nextSeq, list)
+}
+
+// blockListPart answers with the first part of list, the rest is kept for the next request.
+func (s *Server) blockListPart(session *serverSession, request []byte, seq byte, list []byte) []byte {
+	// header, user data parameters and data header take 26 bytes
+	size := (session.pduLength - 26) / 4 * 4
+	if len(list) <= size {
+		return userDataResponse(request, seq, false, 0, list)
+	}
+	if session.blockLists == nil {
+		session.blockLists = make(map[byte][]byte)
+	}
+	session.blockLists[seq] = list[size:]
+	return userDataResponse(request, seq, true, 0, list[:size])
+}
+
+// blockInfo answers a block info request.
+func (s *Server) blockInfo(request []byte) []byte {
+	payload := userDataPayload(request)
+	if len(payload) < 7 {
+		return userDataResponse(request, 0, false, code7InvalidValue, nil)
+	}
+	blockType := int(payload[1])
+	number, err := strconv.Atoi(string(payload[2:7]))
+	if err != nil {
+		return userDataResponse(request, 0, false, code7InvalidValue, nil)
+	}
+	s.mu.Lock()
+	_, ok := s.blocks[blockType][number]
+	if _, db := s.dbs[number]; blockType == blockDB && db {
+		ok = true
+	}
+	info := s.blockInfoLocked(blockType, number)
+	s.mu.Unlock()
+	if !ok {
+		return userDataResponse(request, 0, false, code7ResItemNotAvailable1, nil)
+	}
+	data := make([]byte, 78)
+	data[0] = 0x01
+	data[1] = byte(blockType)
+	data[9] = byte(info.BlkFlags)
+	data[10] = byte(info.BlkLang)
+	data[11] = byte(info.BlkType)
+	binary.BigEndian.PutUint16(data[12:], uint16(number))
+	binary.BigEndian.PutUint32(data[14:], uint32(info.LoadSize))
+	binary.BigEndian.PutUint16(data[26:], siemensDays(info.CodeDate))
+	binary.BigEndian.PutUint16(data[32:], siemensDays(info.IntfDate))
+	binary.BigEndian.PutUint16(data[34:], uint16(info.SBBLength))
+	binary.BigEndian.PutUint16(data[38:], uint16(info.LocalData))
+	binary.BigEndian.PutUint16(data[40:], uint16(info.MC7Size))
+	copy(data[42:50], info.Author)
+	copy(data[50:58], info.Family)
+	copy(data[58:66], info.Header)
+	data[66] = byte(info.Version)
+	binary.BigEndian.PutUint16(data[68:], uint16(info.CheckSum))
+	return userDataResponse(request, 0, false, 0, data)
+}
+
+// blockInfoLocked returns the info of a block completed with the defaults. Caller must hold the mutex.
+func (s *Server) blockInfoLocked(blockType, number int) S7BlockInfo {
+	info := s.blocks[blockType][number]
+	info.BlkNumber = number
+	switch blockType {
+	case blockOB:
+		info.BlkType = 0x08
+	case blockDB:
+		info.BlkType = 0x0A
+	case blockSDB:
+		info.BlkType = 0x0B
+	case blockFC:
+		info.BlkType = 0x0C
+	case blockSFC:
+		info.BlkType = 0x0D
+	case blockFB:
+		info.BlkType = 0x0E
+	case blockSFB:
+		info.BlkType = 0x0F
+	}
+	if info.BlkLang == 0 {
+		info.BlkLang = 1 // AWL
+		if blockType == blockDB {
+			info.BlkLang = 5 // DB
+		}
+	}
+	if db, ok := s.dbs[number]; ok && blockType == blockDB && info.MC7Size == 0 {
+		info.MC7Size = len(db)
+	}
+	if info.LoadSize == 0 {
+		info.LoadSize = info.MC7Size
+	}
+	return info
+}
+
+// readClock answers a clock read with the CPU time.
+func (s *Server) readClock(request []byte) []byte {
+	s.mu.Lock()
+	now := time.Now().Add(s.clock).UTC()
+	s.mu.Unlock()
+	data := make([]byte, 10)
+	data[1] = encodeBcd(now.Year() / 100)
+	var helper Helper
+	helper.SetDateTimeAt(data, 2, now)
+	return userDataResponse(request, 0, false, 0, data)
+}
+
+// setClock answers a clock write by moving the CPU time.
+func (s *Server) setClock(request []byte) []byte {
+	payload := userDataPayload(request)
+	if len(payload) < 10 {
+		return userDataResponse(request, 0, false, code7InvalidValue, nil)
+	}
+	var helper Helper
+	t := helper.GetDateTimeAt(payload, 2)
+	s.mu.Lock()
+	s.clock = time.Until(t)
+	s.mu.Unlock()
+	return userDataResponse(request, 0, false, 0, nil)
+}
+
+// siemensDays returns a date as "02.01.2006" in days since 1984-01-01, the
+// inverse of siemensTimestamp. Invalid dates give 0.
+func siemensDays(date string) uint16 {
+	t, err := time.Parse("02.01.2006", date)
+	if err != nil {
+		return 0
+	}
+	return uint16(t.Sub(time.Date(1984, 1, 1, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+}
+
+// padRight pads text with blanks to size bytes.
+func padRight(text string, size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = ' '
+	}
+	copy(b, text)
+	return b
+}
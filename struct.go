@@ -0,0 +1,461 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// structReadGap is the largest gap between two fields which are read with one
+// item, as reading the gap is cheaper than another item.
+const structReadGap = 16
+
+// structField is a field of a struct mapped to a data block.
+type structField struct {
+	name    string // path of the field, e.g. Motor.Speed
+	index   []int
+	address Address // DBNumber is set on access
+}
+
+var structLayouts sync.Map // reflect.Type -> []structField
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// structLayout returns the mapped fields of struct type t.
+func structLayout(t reflect.Type) ([]structField, error) {
+	if layout, ok := structLayouts.Load(t); ok {
+		return layout.([]structField), nil
+	}
+	var fields []structField
+	var err error
+	if placedFields(t) {
+		err = layoutFields(t, 0, "", nil, &fields)
+	} else {
+		err = marshalFields(t, &fields)
+	}
+	if err != nil {
+		return nil, err
+	}
+	structLayouts.Store(t, fields)
+	return fields, nil
+}
+
+// placedFields reports whether a field of struct type t is tagged with its
+// offset or address. Then only the tagged fields are mapped, else all fields are
+// laid out like Marshal does.
+func placedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		tag := normalizeTag(t.Field(i).Tag.Get("s7"))
+		if tag != "" && tag != "-" && (!strings.Contains(tag, "=") || strings.Contains(tag, "OFFSET=")) {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalFields adds the fields of t in the layout of Marshal to fields, the
+// elements of arrays one by one.
+func marshalFields(t reflect.Type, fields *[]structField) error {
+	var l memoryLayout
+	l.leaf = func(v reflect.Value, a Address) error {
+		if err := checkFieldType(a, v.Type()); err != nil {
+			return err
+		}
+		*fields = append(*fields, structField{l.path[1:], append([]int(nil), l.index...), a})
+		return nil
+	}
+	return l.structValue(reflect.New(t).Elem())
+}
+
+// layoutFields adds the fields of t, which starts at byte base, to fields.
+// Nested structs are mapped with offsets relative to their own offset.
+func layoutFields(t reflect.Type, base int, prefix string, index []int, fields *[]structField) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("s7")
+		if !ok || tag == "-" || !f.IsExported() {
+			continue
+		}
+		name := prefix + f.Name
+		fieldIndex := append(append([]int(nil), index...), i)
+		if f.Type.Kind() == reflect.Struct && f.Type != timeType {
+			offset, _, err := parseFieldOptions(normalizeTag(tag))
+			start, serr := strconv.Atoi(offset)
+			if err != nil || serr != nil {
+				return fmt.Errorf("s7: field %s: a struct needs an offset, not %q", name, tag)
+			}
+			if err := layoutFields(f.Type, base+start, name+".", fieldIndex, fields); err != nil {
+				return err
+			}
+			continue
+		}
+		address, placed, err := parseFieldTag(tag, f.Type)
+		if err == nil && !placed {
+			err = fmt.Errorf("no offset in %q", tag)
+		}
+		if err != nil {
+			return fmt.Errorf("s7: field %s: %w", name, err)
+		}
+		address.Start += base
+		if err = checkFieldType(address, f.Type); err != nil {
+			return fmt.Errorf("s7: field %s: %w", name, err)
+		}
+		*fields = append(*fields, structField{name, fieldIndex, address})
+	}
+	return nil
+}
+
+// checkFieldType returns an error if a field of Go type t cannot hold the values
+// at address a.
+func checkFieldType(a Address, t reflect.Type) error {
+	vt, err := a.goType()
+	if err != nil {
+		return err
+	}
+	if !assignable(vt, t) {
+		return fmt.Errorf("%v cannot hold the %v at %v", t, vt, a)
+	}
+	return nil
+}
+
+// normalizeTag returns an s7 tag in upper case without spaces.
+func normalizeTag(tag string) string {
+	return strings.ToUpper(strings.ReplaceAll(tag, " ", ""))
+}
+
+// parseFieldTag parses the s7 tag of a field whose values are of Go type t, for
+// ReadStruct and Marshal: options like "offset=4,type=REAL", "offset=12.3" or
+// "type=STRING[20]", or a data block address like "DBX12.3" or "DBD4:REAL".
+// Without a type the type follows from t. placed reports whether the tag gives
+// the offset, else the address starts at 0.
+func parseFieldTag(tag string, t reflect.Type) (a Address, placed bool, err error) {
+	tag = normalizeTag(tag)
+	placed = true
+	if tag == "" || strings.Contains(tag, "=") {
+		offset, typ, err := parseFieldOptions(tag)
+		if err != nil {
+			return a, false, err
+		}
+		if typ == "" {
+			typ = defaultFieldType(t)
+		}
+		if typ == "" {
+			return a, false, fmt.Errorf("no S7 type for %v, tag it with a type", t)
+		}
+		if placed = offset != ""; !placed {
+			offset = "0"
+			if typ == "BOOL" {
+				offset = "0.0"
+			}
+		}
+		if strings.Contains(offset, ".") || typ == "BOOL" {
+			tag = "DBX" + offset
+			if typ != "BOOL" && typ != "" {
+				tag += ":" + typ
+			}
+		} else {
+			tag = "DBB" + offset
+			if typ != "" {
+				tag += ":" + typ
+			}
+		}
+	} else if !strings.Contains(tag, ":") && !strings.HasPrefix(tag, "DBX") {
+		if typ := defaultFieldType(t); typ != "" {
+			tag += ":" + typ
+		}
+	}
+	a, err = ParseAddress("DB0." + tag)
+	return a, placed, err
+}
+
+// parseFieldOptions returns the offset and the type of the options in a
+// normalized s7 tag, empty if they are not given.
+func parseFieldOptions(tag string) (offset, typ string, err error) {
+	if tag == "" {
+		return "", "", nil
+	}
+	for _, option := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "OFFSET":
+			offset = value
+		case "TYPE":
+			typ = value
+		default:
+			return "", "", fmt.Errorf("unknown option %q", option)
+		}
+	}
+	return offset, typ, nil
+}
+
+// defaultFieldType returns the S7 type of a field of Go type t.
+func defaultFieldType(t reflect.Type) string {
+	switch t {
+	case durationType:
+		return "TIME"
+	case timeType:
+		return "DATE_AND_TIME"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "BOOL"
+	case reflect.Int8:
+		return "SINT"
+	case reflect.Uint8:
+		return "BYTE"
+	case reflect.Int16:
+		return "INT"
+	case reflect.Uint16:
+		return "WORD"
+	case reflect.Int32:
+		return "DINT"
+	case reflect.Uint32:
+		return "DWORD"
+	case reflect.Int64:
+		return "LINT"
+	case reflect.Uint64:
+		return "LWORD"
+	case reflect.Float32:
+		return "REAL"
+	case reflect.Float64:
+		return "LREAL"
+	case reflect.String:
+		return "STRING"
+	}
+	return ""
+}
+
+// assignable reports whether a value of type from can be stored in a field of
+// type to. Integers convert to integers and floats to floats.
+func assignable(from, to reflect.Type) bool {
+	if from.AssignableTo(to) {
+		return true
+	}
+	if from.Kind() == reflect.Slice && to.Kind() == reflect.Slice {
+		return assignable(from.Elem(), to.Elem())
+	}
+	if from == durationType || to == durationType {
+		return false
+	}
+	return numberKind(from) != 0 && numberKind(from) == numberKind(to)
+}
+
+// numberKind returns 1 for integers, 2 for floats and 0 for other types.
+func numberKind(t reflect.Type) int {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return 1
+	case reflect.Float32, reflect.Float64:
+		return 2
+	}
+	return 0
+}
+
+// fieldAt returns the field of struct v at index, which holds the index of the
+// element for an array.
+func fieldAt(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Array {
+			v = v.Index(i)
+		} else {
+			v = v.Field(i)
+		}
+	}
+	return v
+}
+
+// setField stores v in the field, converting numbers and slices of numbers.
+func setField(field reflect.Value, v reflect.Value) {
+	switch {
+	case v.Type().AssignableTo(field.Type()):
+		field.Set(v)
+	case v.Kind() == reflect.Slice:
+		s := reflect.MakeSlice(field.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			setField(s.Index(i), v.Index(i))
+		}
+		field.Set(s)
+	default:
+		field.Set(v.Convert(field.Type()))
+	}
+}
+
+// structValue returns the struct v points to and its layout.
+func structValue(v interface{}) (reflect.Value, []structField, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, nil, fmt.Errorf("s7: %T is not a pointer to a struct", v)
+	}
+	fields, err := structLayout(rv.Elem().Type())
+	return rv.Elem(), fields, err
+}
+
+// byteRange is a range of bytes of a data block read with one item.
+type byteRange struct {
+	start, end int
+	data       []byte
+}
+
+// ReadStruct reads the fields of the struct v points to from data block db. The
+// fields are mapped with s7 tags like `s7:"offset=4,type=REAL"` or
+// `s7:"DBX12.3"`, untagged fields are left alone. Without offsets in the tags
+// all fields are laid out like Marshal does. Fields close to each other are
+// read together, with as few requests as the PDU length allows.
+func (mb *client) ReadStruct(db int, v interface{}) error {
+	return mb.ReadStructContext(context.Background(), db, v)
+}
+
+// ReadStructContext is the context-aware variant of ReadStruct
+func (mb *client) ReadStructContext(ctx context.Context, db int, v interface{}) error {
+	rv, fields, err := structValue(v)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	sorted := append([]structField(nil), fields...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].address.Start < sorted[j].address.Start })
+	var ranges []*byteRange
+	for _, f := range sorted {
+		start, end := f.address.Start, f.address.Start+f.address.Size()
+		if n := len(ranges); n > 0 && start-ranges[n-1].end <= structReadGap {
+			ranges[n-1].end = max(ranges[n-1].end, end)
+			continue
+		}
+		ranges = append(ranges, &byteRange{start: start, end: end})
+	}
+	if len(ranges) == 1 {
+		r := ranges[0]
+		r.data = make([]byte, r.end-r.start)
+		if err = mb.AGReadDBContext(ctx, db, r.start, len(r.data), r.data); err != nil {
+			return err
+		}
+	} else {
+		var batch TagBatch
+		for _, r := range ranges {
+			tag := Tag[[]byte]{Address{Area: AreaDB, DBNumber: db, Start: r.start, Type: TypeByte, Count: r.end - r.start}}
+			BatchRead(&batch, tag, &r.data)
+		}
+		if err = batch.Read(ctx, mb); err != nil {
+			return err
+		}
+	}
+	for _, f := range fields {
+		a := f.address
+		a.DBNumber = db
+		r := ranges[sort.Search(len(ranges), func(i int) bool { return ranges[i].end > a.Start })]
+		value, err := a.Decode(r.data[a.Start-r.start:])
+		if err != nil {
+			return fmt.Errorf("s7: field %s: %w", f.name, err)
+		}
+		setField(fieldAt(rv, f.index), reflect.ValueOf(value))
+	}
+	return nil
+}
+
+// WriteStruct writes all mapped fields of the struct v points to into data block
+// db, see ReadStruct. Adjacent fields are written together, BOOLs as single bits.
+func (mb *client) WriteStruct(db int, v interface{}) error {
+	return mb.WriteStructContext(context.Background(), db, v)
+}
+
+// WriteStructContext is the context-aware variant of WriteStruct
+func (mb *client) WriteStructContext(ctx context.Context, db int, v interface{}) error {
+	rv, fields, err := structValue(v)
+	if err != nil {
+		return err
+	}
+	return mb.writeStructFields(ctx, db, rv, fields)
+}
+
+// WriteStructFields writes only the named fields of the struct v points to, e.g.
+// the fields ChangedFields returns. Fields of nested structs are named by their
+// path, like Motor.Speed.
+func (mb *client) WriteStructFields(db int, v interface{}, names ...string) error {
+	return mb.WriteStructFieldsContext(context.Background(), db, v, names...)
+}
+
+// WriteStructFieldsContext is the context-aware variant of WriteStructFields
+func (mb *client) WriteStructFieldsContext(ctx context.Context, db int, v interface{}, names ...string) error {
+	rv, fields, err := structValue(v)
+	if err != nil {
+		return err
+	}
+	var selected []structField
+	for _, name := range names {
+		i := 0
+		for i < len(fields) && fields[i].name != name {
+			i++
+		}
+		if i == len(fields) {
+			return fmt.Errorf("s7: %v has no mapped field %s", rv.Type(), name)
+		}
+		selected = append(selected, fields[i])
+	}
+	return mb.writeStructFields(ctx, db, rv, selected)
+}
+
+// writeStructFields writes the fields of rv, merging adjacent ones.
+func (mb *client) writeStructFields(ctx context.Context, db int, rv reflect.Value, fields []structField) error {
+	var batch TagBatch
+	var ranges []*byteRange
+	for _, f := range fields {
+		a := f.address
+		a.DBNumber = db
+		value := fieldAt(rv, f.index).Interface()
+		if a.Type == TypeBool {
+			batch.writes = append(batch.writes, batchWrite{a, value})
+			continue
+		}
+		data, err := a.Encode(value)
+		if err != nil {
+			return fmt.Errorf("s7: field %s: %w", f.name, err)
+		}
+		ranges = append(ranges, &byteRange{a.Start, a.Start + len(data), data})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	for i := 0; i < len(ranges); i++ {
+		r := ranges[i]
+		for i+1 < len(ranges) && ranges[i+1].start == r.end {
+			r.data = append(r.data, ranges[i+1].data...)
+			r.end = ranges[i+1].end
+			i++
+		}
+		address := Address{Area: AreaDB, DBNumber: db, Start: r.start, Type: TypeByte, Count: len(r.data)}
+		batch.writes = append(batch.writes, batchWrite{address, r.data})
+	}
+	return batch.Write(ctx, mb)
+}
+
+// ChangedFields returns the names of the mapped fields whose values differ
+// between the structs old and new point to, for WriteStructFields.
+func ChangedFields(old, new interface{}) ([]string, error) {
+	ov, fields, err := structValue(old)
+	if err != nil {
+		return nil, err
+	}
+	nv, _, err := structValue(new)
+	if err != nil {
+		return nil, err
+	}
+	if ov.Type() != nv.Type() {
+		return nil, fmt.Errorf("s7: cannot compare %v with %v", ov.Type(), nv.Type())
+	}
+	var names []string
+	for _, f := range fields {
+		if !reflect.DeepEqual(fieldAt(ov, f.index).Interface(), fieldAt(nv, f.index).Interface()) {
+			names = append(names, f.name)
+		}
+	}
+	return names, nil
+}
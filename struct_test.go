@@ -0,0 +1,108 @@
+package gos7
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStruct(t *testing.T) {
+	type motor struct {
+		Speed   float32 `s7:"offset=0,type=REAL"`
+		Running bool    `s7:"offset=4.0"`
+	}
+	type recipe struct {
+		Name    string        `s7:"DBB0:STRING[10]"`
+		Count   int           `s7:"offset=12,type=INT"`
+		Enabled bool          `s7:"DBX14.1"`
+		Mixing  time.Duration `s7:"DBD16"`
+		Motor   motor         `s7:"offset=100"`
+		Levels  []int16       `s7:"offset=200,type=INT[3]"`
+		Note    string
+	}
+	server := NewServer()
+	db := make([]byte, 256)
+	server.SetDB(3, db)
+	var jobs int
+	count := ObserverFunc(func(JobMetrics) { jobs++ })
+	_, client := newTestServerClient(t, server, func(h *TCPClientHandler) { h.Observer = count })
+
+	want := recipe{Name: "bread", Count: 12, Enabled: true, Mixing: 90 * time.Second,
+		Motor: motor{Speed: 1.5, Running: true}, Levels: []int16{1, -2, 3}}
+	if err := client.WriteStruct(3, &want); err != nil {
+		t.Fatal(err)
+	}
+	var got recipe
+	jobs = 0
+	if err := client.ReadStruct(3, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) || jobs != 1 {
+		t.Fatalf("unexpected struct after %d jobs: %+v", jobs, got)
+	}
+
+	changed := got
+	changed.Count, changed.Motor.Running = 13, false
+	names, err := ChangedFields(&got, &changed)
+	if err != nil || !reflect.DeepEqual(names, []string{"Count", "Motor.Running"}) {
+		t.Fatalf("unexpected changed fields: %v %v", names, err)
+	}
+	server.SetDB(3, make([]byte, 256))
+	if err = client.WriteStructFields(3, &changed, names...); err != nil {
+		t.Fatal(err)
+	}
+	if db, _ := server.DB(3); db[13] != 13 || db[2] != 0 || db[16] != 0 {
+		t.Fatalf("unexpected DB: %x", db[:20])
+	}
+
+	var wrong struct {
+		Speed int16 `s7:"DBD0:REAL"`
+	}
+	if err = client.ReadStruct(3, &wrong); err == nil {
+		t.Fatal("expected error on a field of the wrong Go type")
+	}
+}
+
+func TestStructMarshalLayout(t *testing.T) {
+	type placed struct {
+		Speed float32 `s7:"offset=0,type=REAL"`
+		Name  string  `s7:"offset=4,type=STRING[20]"`
+		Done  bool    `s7:"DBX26.0"`
+	}
+	type laidOut struct {
+		Speed  float32
+		Name   string `s7:"type=STRING[20]"`
+		Done   bool
+		Levels [2]int16
+	}
+	server := NewServer()
+	_, client := newTestServerClient(t, server)
+	// the same tags work with Marshal and ReadStruct, with or without offsets
+	for _, v := range []interface{}{
+		&placed{Speed: 1.5, Name: "pump", Done: true},
+		&laidOut{Speed: 1.5, Name: "pump", Done: true, Levels: [2]int16{1, -2}},
+	} {
+		server.SetDB(4, make([]byte, 64))
+		b, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("%T: %v", v, err)
+		}
+		if err = client.WriteStruct(4, v); err != nil {
+			t.Fatalf("%T: %v", v, err)
+		}
+		if db, _ := server.DB(4); !bytes.Equal(db[:len(b)], b) {
+			t.Fatalf("%T: WriteStruct wrote %x, Marshal %x", v, db[:len(b)], b)
+		}
+		got := reflect.New(reflect.TypeOf(v).Elem()).Interface()
+		if err = client.ReadStruct(4, got); err != nil || !reflect.DeepEqual(got, v) {
+			t.Fatalf("%T: unexpected struct %+v %v", v, got, err)
+		}
+	}
+	var misplaced struct {
+		Speed float32 `s7:"offset=2,type=REAL"`
+	}
+	if _, err := Marshal(&misplaced); err == nil {
+		t.Fatal("expected error on an offset off the layout")
+	}
+}
@@ -0,0 +1,233 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	supervisorInterval    = 5 * time.Second
+	supervisorMaxFailures = 3
+)
+
+// ConnectionState is the state of a connection watched by a Supervisor.
+type ConnectionState int
+
+// States of a supervised connection
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+	StateDegraded // connected, but the last probe failed or was slow
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDegraded:
+		return "degraded"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
+// StateEvent reports that a supervised connection changed its state.
+type StateEvent struct {
+	From, To ConnectionState
+	Err      error // cause of the change, nil when a connect or probe succeeded
+	Latency  time.Duration
+}
+
+// errConnectionClosed is the cause reported when the connection was closed
+// outside the Supervisor, e.g. by the idle timer or by a failed request.
+var errConnectionClosed = errors.New("s7: connection closed")
+
+// Supervisor watches the connection of a TCPClientHandler. It probes the PLC
+// every Interval with a cheap job, so a half-open socket is detected before a
+// request runs into it, and it connects again when the connection is lost. The
+// probes keep the connection active, so an Interval below the IdleTimeout of
+// the handler keeps it from being closed as idle.
+type Supervisor struct {
+	// Interval is the time between two probes, 0 uses 5s
+	Interval time.Duration
+	// Timeout bounds a probe, 0 uses the Timeout of the handler
+	Timeout time.Duration
+	// MaxFailures is the number of failed probes in a row after which the
+	// connection is closed and connected again, 0 uses 3. Fewer degrade it.
+	MaxFailures int
+	// SlowProbe degrades the connection when a probe takes longer, 0 disables it
+	SlowProbe time.Duration
+	// Probe is the job sent to the PLC, nil reads the CPU status
+	Probe func(ctx context.Context, client Client) error
+	// OnStateChange is called on every change of the state.
+	OnStateChange func(StateEvent)
+
+	handler *TCPClientHandler
+	client  Client
+
+	mu     sync.Mutex
+	state  ConnectionState
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewSupervisor allocates a new Supervisor for handler.
+func NewSupervisor(handler *TCPClientHandler) *Supervisor {
+	return &Supervisor{handler: handler, client: NewClient(handler)}
+}
+
+// State returns the current state of the connection.
+func (s *Supervisor) State() ConnectionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Start starts watching the connection in the background, connecting the
+// handler first if it is not connected.
+func (s *Supervisor) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel, s.done = cancel, make(chan struct{})
+	if s.handler.connected() {
+		s.state = StateConnected
+	}
+	go s.run(ctx, s.done)
+}
+
+// Stop stops watching the connection and waits for a running probe. The
+// connection is left as it is.
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (s *Supervisor) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	interval := s.Interval
+	if interval <= 0 {
+		interval = supervisorInterval
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		next := interval
+		if !s.handler.connected() {
+			if s.State() != StateDisconnected {
+				s.setState(StateDisconnected, errConnectionClosed, 0)
+			}
+			s.connect(ctx)
+		} else if latency, err := s.probe(ctx); ctx.Err() != nil {
+			return
+		} else if err != nil {
+			failures++
+			if failures < s.maxFailures() && s.handler.connected() {
+				s.setState(StateDegraded, err, latency)
+			} else {
+				// a half-open socket, or a PLC which stopped answering
+				s.handler.Close()
+				s.setState(StateDisconnected, err, latency)
+				failures = 0
+				next = 0
+			}
+		} else {
+			failures = 0
+			if s.SlowProbe > 0 && latency > s.SlowProbe {
+				s.setState(StateDegraded, fmt.Errorf("s7: probe took %v", latency), latency)
+			} else {
+				s.setState(StateConnected, nil, latency)
+			}
+		}
+		timer.Reset(next)
+	}
+}
+
+// connect runs the connect sequence of the handler.
+func (s *Supervisor) connect(ctx context.Context) {
+	s.setState(StateConnecting, nil, 0)
+	start := time.Now()
+	if err := s.handler.ConnectContext(ctx); err != nil {
+		if ctx.Err() == nil {
+			s.setState(StateDisconnected, err, 0)
+		}
+		return
+	}
+	s.setState(StateConnected, nil, time.Since(start))
+}
+
+// probe sends the Probe job and returns its latency.
+func (s *Supervisor) probe(ctx context.Context) (latency time.Duration, err error) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = s.handler.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	if s.Probe != nil {
+		err = s.Probe(ctx, s.client)
+	} else {
+		_, err = s.client.PLCGetStatusContext(ctx)
+	}
+	return time.Since(start), err
+}
+
+func (s *Supervisor) maxFailures() int {
+	if s.MaxFailures <= 0 {
+		return supervisorMaxFailures
+	}
+	return s.MaxFailures
+}
+
+// setState changes the state and reports the change to OnStateChange.
+func (s *Supervisor) setState(state ConnectionState, cause error, latency time.Duration) {
+	s.mu.Lock()
+	from := s.state
+	s.state = state
+	s.mu.Unlock()
+	if from == state {
+		return
+	}
+	level := slog.LevelInfo
+	if cause != nil {
+		level = slog.LevelWarn
+	}
+	args := []any{"from", from.String(), "to", state.String()}
+	if cause != nil {
+		args = append(args, "error", cause)
+	}
+	s.handler.log(level, "s7: connection state changed", args...)
+	if s.OnStateChange != nil {
+		s.OnStateChange(StateEvent{From: from, To: state, Err: cause, Latency: latency})
+	}
+}
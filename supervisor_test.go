@@ -0,0 +1,86 @@
+package gos7
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSupervisor(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var silent, fail atomic.Bool
+	// a silent PLC keeps the socket open but stops answering
+	go serveFakePLC(ln, func(request []byte) []byte {
+		for silent.Load() {
+			time.Sleep(5 * time.Millisecond)
+		}
+		return []byte{3, 0, 0, 26, 2, 0xF0, 0x80, 0x32, 3, 0, 0, request[11], request[12], 0, 2, 0, 5, 0, 0,
+			4, 1, 0xFF, 4, 0, 8, 1}
+	})
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	defer handler.Close()
+	events := make(chan StateEvent, 1000)
+	errProbe := errors.New("probe failed")
+	supervisor := NewSupervisor(handler)
+	supervisor.Interval = 10 * time.Millisecond
+	supervisor.Timeout = 50 * time.Millisecond
+	supervisor.MaxFailures = 2
+	supervisor.Probe = func(ctx context.Context, client Client) error {
+		if fail.Load() {
+			return errProbe
+		}
+		return client.AGReadDBContext(ctx, 1, 0, 1, make([]byte, 1))
+	}
+	supervisor.OnStateChange = func(event StateEvent) {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+	wait := func(state ConnectionState) StateEvent {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case event := <-events:
+				if event.To == state {
+					return event
+				}
+			case <-timeout:
+				t.Fatalf("no transition to %v", state)
+			}
+		}
+	}
+	supervisor.Start()
+	defer supervisor.Stop()
+	wait(StateConnected)
+
+	fail.Store(true)
+	if event := wait(StateDegraded); event.From != StateConnected || event.Err != errProbe {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	if event := wait(StateDisconnected); event.Err != errProbe {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	fail.Store(false)
+	wait(StateConnecting)
+	wait(StateConnected)
+
+	// the probe times out on the half-open connection
+	silent.Store(true)
+	if event := wait(StateDisconnected); event.Err == nil {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	silent.Store(false)
+	wait(StateConnected)
+	if state := supervisor.State(); state != StateConnected {
+		t.Fatalf("unexpected state: %v", state)
+	}
+}
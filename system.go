@@ -4,11 +4,16 @@ package gos7
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"strings"
 )
 
+// errSZLNotAvailable is returned by readSzl when the CPU does not know the SZL ID or index.
+var errSZLNotAvailable = errors.New(ErrorText(errCliItemNotAvailable))
+
 //SZLHeader See §33.1 of "System Software for S7-300/400 System and Standard Functions" and see SFC51 description too
 type SZLHeader struct {
 	LengthHeader       uint16
@@ -63,8 +68,13 @@ type S7CpInfo struct {
 
 //implement GetCPUInfo
 func (mb *client) GetCPUInfo() (info S7CpuInfo, err error) {
+	return mb.GetCPUInfoContext(context.Background())
+}
 
-	szl, _, err := mb.readSzl(0x001C, 0x000)
+//implement GetCPUInfoContext
+func (mb *client) GetCPUInfoContext(ctx context.Context) (info S7CpuInfo, err error) {
+
+	szl, _, err := mb.readSzl(ctx, 0x001C, 0x000)
 	if err == nil {
 		moduleTypeName := string(szl.Data[172 : 172+32])
 		serialNumber := string(szl.Data[138 : 138+24])
@@ -83,7 +93,12 @@ func (mb *client) GetCPUInfo() (info S7CpuInfo, err error) {
 
 //implement of GetCPInfo
 func (mb *client) GetCPInfo() (info S7CpInfo, err error) {
-	szl, _, err := mb.readSzl(0x0131, 0x000)
+	return mb.GetCPInfoContext(context.Background())
+}
+
+//implement of GetCPInfoContext
+func (mb *client) GetCPInfoContext(ctx context.Context) (info S7CpInfo, err error) {
+	szl, _, err := mb.readSzl(ctx, 0x0131, 0x000)
 	if err == nil {
 		info.MaxPduLength = int(binary.BigEndian.Uint16(szl.Data[2:]))
 		info.MaxConnections = int(binary.BigEndian.Uint16(szl.Data[4:]))
@@ -95,7 +110,12 @@ func (mb *client) GetCPInfo() (info S7CpInfo, err error) {
 
 //implement of GetOrderCode
 func (mb *client) GetOrderCode() (info S7OrderCode, err error) {
-	szl, size, err := mb.readSzl(0x0131, 0x000)
+	return mb.GetOrderCodeContext(context.Background())
+}
+
+//implement of GetOrderCodeContext
+func (mb *client) GetOrderCodeContext(ctx context.Context) (info S7OrderCode, err error) {
+	szl, size, err := mb.readSzl(ctx, 0x0131, 0x000)
 	if err == nil {
 		info.Code = string(szl.Data[2 : 2+20])
 		info.V1 = szl.Data[size-3]
@@ -106,7 +126,7 @@ func (mb *client) GetOrderCode() (info S7OrderCode, err error) {
 }
 
 //internal function readSZL
-func (mb *client) readSzl(id int, index int) (szl S7SZL, size int, err error) {
+func (mb *client) readSzl(ctx context.Context, id int, index int) (szl S7SZL, size int, err error) {
 	var dataSZL int
 	offset := 0
 	var done bool
@@ -127,13 +147,13 @@ func (mb *client) readSzl(id int, index int) (szl S7SZL, size int, err error) {
 			binary.BigEndian.PutUint16(s7SZLFirst[31:], uint16(index))
 			request := NewProtocolDataUnit(s7SZLFirst)
 			//send
-			res, err = mb.send(&request)
+			res, err = mb.send(ctx, &request)
 		} else {
 			binary.BigEndian.PutUint16(s7SZLNext[11:], seqOut+1)
 			s7SZLNext[24] = byte(seqIn)
 			request := NewProtocolDataUnit(s7SZLNext)
 			//send
-			res, err = mb.send(&request)
+			res, err = mb.send(ctx, &request)
 		}
 		if err != nil {
 			return
@@ -142,8 +162,11 @@ func (mb *client) readSzl(id int, index int) (szl S7SZL, size int, err error) {
 			err = fmt.Errorf(ErrorText(errIsoInvalidPDU))
 			return
 		}
-		if binary.BigEndian.Uint16(res.Data[27:]) != 0 && res.Data[29] != byte(0xFF) {
+		if code := binary.BigEndian.Uint16(res.Data[27:]); code != 0 && res.Data[29] != byte(0xFF) {
 			err = fmt.Errorf(ErrorText(errCliInvalidPlcAnswer))
+			if code == code7InvalidSZLID || CPUError(uint(code)) == errCliItemNotAvailable || res.Data[29] == code7ResItemNotAvailable {
+				err = errSZLNotAvailable
+			}
 			return
 		}
 		if first {
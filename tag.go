@@ -0,0 +1,242 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// maxBatchItems is the number of items of a multi read or write.
+const maxBatchItems = 20
+
+// Tag is a value of Go type T at an Address, e.g. a Tag[float32] at
+// DB10.DBD4:REAL or a Tag[[]int16] at DB5.DBW0:INT[10]. See DataType for the Go
+// types of the S7 types.
+type Tag[T any] struct {
+	Address
+}
+
+// NewTag returns the tag at an address in S7 syntax, see ParseAddress. It fails
+// if the values at the address are not of type T.
+func NewTag[T any](address string) (tag Tag[T], err error) {
+	if tag.Address, err = ParseAddress(address); err != nil {
+		return
+	}
+	t, err := tag.goType()
+	if err != nil {
+		return
+	}
+	if want := reflect.TypeOf((*T)(nil)).Elem(); !t.AssignableTo(want) {
+		return tag, fmt.Errorf("s7: %v holds %v, not %v", tag.Address, t, want)
+	}
+	return
+}
+
+// MustTag is like NewTag but panics on error. It simplifies the declaration of
+// the tags of a PLC in variables.
+func MustTag[T any](address string) Tag[T] {
+	tag, err := NewTag[T](address)
+	if err != nil {
+		panic(err)
+	}
+	return tag
+}
+
+// value returns v as T.
+func (tag Tag[T]) value(v interface{}) (T, error) {
+	value, ok := v.(T)
+	if !ok {
+		return value, fmt.Errorf("s7: %v holds %T, not %T", tag.Address, v, value)
+	}
+	return value, nil
+}
+
+// ReadTag reads the value of a tag.
+func ReadTag[T any](ctx context.Context, client Client, tag Tag[T]) (value T, err error) {
+	v, err := client.ReadContext(ctx, tag.String(), nil)
+	if err != nil {
+		return
+	}
+	return tag.value(v)
+}
+
+// WriteTag writes the value of a tag. A BOOL is written as a single bit.
+func WriteTag[T any](ctx context.Context, client Client, tag Tag[T], value T) error {
+	return client.WriteContext(ctx, tag.String(), value)
+}
+
+// TagBatch reads or writes many tags with few requests. The tags are packed into
+// multi reads and writes of up to 20 items, as many as fit into the PDU. Tags
+// larger than a PDU are read or written on their own.
+type TagBatch struct {
+	reads  []batchRead
+	writes []batchWrite
+}
+
+type batchRead struct {
+	address Address
+	set     func(v interface{}) error
+}
+
+type batchWrite struct {
+	address Address
+	value   interface{}
+}
+
+// BatchRead adds a tag to read into value to the batch.
+func BatchRead[T any](b *TagBatch, tag Tag[T], value *T) {
+	b.reads = append(b.reads, batchRead{tag.Address, func(v interface{}) (err error) {
+		*value, err = tag.value(v)
+		return
+	}})
+}
+
+// BatchWrite adds a tag to write value to to the batch.
+func BatchWrite[T any](b *TagBatch, tag Tag[T], value T) {
+	b.writes = append(b.writes, batchWrite{tag.Address, value})
+}
+
+// batchPDULength returns the PDU length negotiated by the client.
+func batchPDULength(c Client) int {
+	if s, ok := c.(interface{ session() SessionParams }); ok {
+		return s.session().PDULength
+	}
+	return minPduLength
+}
+
+// Read reads the tags added with BatchRead. Tags which the PLC refused are
+// reported in the error, the others are read nevertheless.
+func (b *TagBatch) Read(ctx context.Context, client Client) error {
+	pduLength := batchPDULength(client)
+	var errs []error
+	var items []S7DataItem
+	var reads []batchRead
+	flush := func() error {
+		if len(items) == 0 {
+			return nil
+		}
+		if err := client.AGReadMultiContext(ctx, items, len(items)); err != nil {
+			return err
+		}
+		for i, item := range items {
+			if item.Error != "" {
+				errs = append(errs, fmt.Errorf("s7: read %v: %s", reads[i].address, item.Error))
+				continue
+			}
+			v, err := reads[i].address.Decode(item.Data)
+			if err == nil {
+				err = reads[i].set(v)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		items, reads = items[:0], reads[:0]
+		return nil
+	}
+	// sizes of the request and the response with the ISO header, like AGReadMulti counts them
+	requestSize, responseSize := 19, 21
+	for _, r := range b.reads {
+		size := r.address.Size()
+		itemSize := 4 + size + size%2
+		if 21+itemSize > pduLength {
+			v, err := client.ReadContext(ctx, r.address.String(), nil)
+			if err == nil {
+				err = r.set(v)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if len(items) == maxBatchItems || requestSize+12 > pduLength || responseSize+itemSize > pduLength {
+			if err := flush(); err != nil {
+				return err
+			}
+			requestSize, responseSize = 19, 21
+		}
+		items = append(items, r.address.DataItem(make([]byte, size)))
+		reads = append(reads, r)
+		requestSize += 12
+		responseSize += itemSize
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// Write writes the tags added with BatchWrite. A BOOL is written as single bits.
+// Tags which the PLC refused are reported in the error, the others are written
+// nevertheless.
+func (b *TagBatch) Write(ctx context.Context, client Client) error {
+	pduLength := batchPDULength(client)
+	var errs []error
+	var items []S7DataItem
+	var addresses []Address
+	flush := func() error {
+		if len(items) == 0 {
+			return nil
+		}
+		if err := client.AGWriteMultiContext(ctx, items, len(items)); err != nil {
+			return err
+		}
+		for i, item := range items {
+			if item.Error != "" {
+				errs = append(errs, fmt.Errorf("s7: write %v: %s", addresses[i], item.Error))
+			}
+		}
+		items, addresses = items[:0], addresses[:0]
+		return nil
+	}
+	// size of the request with the ISO header, like AGWriteMulti counts it
+	requestSize := 19
+	add := func(address Address, item S7DataItem) error {
+		itemSize := 12 + 4 + len(item.Data) + len(item.Data)%2
+		if len(items) == maxBatchItems || requestSize+itemSize > pduLength {
+			if err := flush(); err != nil {
+				return err
+			}
+			requestSize = 19
+		}
+		items = append(items, item)
+		addresses = append(addresses, address)
+		requestSize += itemSize
+		return nil
+	}
+	for _, w := range b.writes {
+		a := w.address
+		data, err := a.Encode(w.value)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if a.Type == TypeBool {
+			for i, bit := range data {
+				item := S7DataItem{Area: a.Area, DBNumber: a.DBNumber, WordLen: s7wlbit,
+					Start: a.Start*8 + a.Bit + i, Amount: 1, Data: []byte{bit}}
+				if err = add(a, item); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if 19+16+len(data)+len(data)%2 > pduLength {
+			if err = client.WriteContext(ctx, a.String(), w.value); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if err = add(a, a.DataItem(data)); err != nil {
+			return err
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,60 @@
+package gos7
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	server := NewServer()
+	server.PDULength = 240
+	db := make([]byte, 400)
+	for i := 0; i < 25; i++ {
+		binary.BigEndian.PutUint16(db[i*2:], uint16(i))
+	}
+	server.SetDB(1, db)
+	var jobs int
+	count := ObserverFunc(func(JobMetrics) { jobs++ })
+	_, client := newTestServerClient(t, server, func(h *TCPClientHandler) { h.Observer = count })
+	ctx := context.Background()
+
+	if _, err := NewTag[int16]("DB1.DBD60:REAL"); err == nil {
+		t.Fatal("expected error on a tag of the wrong Go type")
+	}
+	speed := MustTag[float32]("DB1.DBD60:REAL")
+	if err := WriteTag(ctx, client, speed, 21.5); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ReadTag(ctx, client, speed); err != nil || v != 21.5 {
+		t.Fatalf("unexpected value: %v %v", v, err)
+	}
+
+	// 25 INTs fit into two multi reads, the 300 bytes need a read of their own
+	var batch TagBatch
+	values := make([]int16, 25)
+	for i := range values {
+		BatchRead(&batch, MustTag[int16](fmt.Sprintf("DB1.DBW%d:INT", i*2)), &values[i])
+	}
+	var large []byte
+	BatchRead(&batch, MustTag[[]byte]("DB1.DBB64:BYTE[300]"), &large)
+	jobs = 0
+	if err := batch.Read(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	if values[24] != 24 || len(large) != 300 || jobs != 4 {
+		t.Fatalf("unexpected values after %d jobs: %v %d", jobs, values, len(large))
+	}
+
+	batch = TagBatch{}
+	BatchWrite(&batch, MustTag[string]("DB1.DBB50:STRING[8]"), "pump")
+	BatchWrite(&batch, MustTag[[]bool]("DB1.DBX63.6:BOOL[2]"), []bool{true, true})
+	BatchWrite(&batch, MustTag[int16]("DB1.DBW0:INT"), -1)
+	if err := batch.Write(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	if db, _ := server.DB(1); db[63] != 0xC0 || string(db[52:56]) != "pump" || db[0] != 0xFF {
+		t.Fatalf("unexpected DB: %x", db[:64])
+	}
+}
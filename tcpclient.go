@@ -4,10 +4,12 @@ package gos7
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
-	"io"
 	"log"
+	"log/slog"
 	"net"
 	"strconv"
 	"strings"
@@ -19,12 +21,14 @@ const (
 	// Default TCP timeout is not set
 	tcpTimeout     = 10 * time.Second
 	tcpIdleTimeout = 60 * time.Second
-	tcpMaxLength   = 2084
 	//messages
-	pduSizeRequested = 480
+	pduSizeRequested = 480 // default PDU length requested
+	amqRequested     = 1   // default parallel jobs requested
 	isoTCP           = 102 //default isotcp port
 	isoHSize         = 7   // TPKT+COTP Header Size
 	minPduSize       = 16
+	minPduLength     = 240 // smallest PDU length an S7 CPU negotiates
+	maxTSAPLength    = 241 // local and remote TSAP together, the CR header must not exceed 254 bytes
 	// Client Connection Type
 	connectionTypePG    = 1 // Connect to the PLC as a PG
 	connectionTypeOP    = 2 // Connect to the PLC as an OP
@@ -96,220 +100,319 @@ type tcpTransporter struct {
 	IdleTimeout time.Duration
 	// Transmission logger
 	Logger *log.Logger
+	// Log is the structured logger, it takes precedence over Logger. Requests are
+	// logged at debug level, refused requests at warn and failed ones at error level.
+	Log *slog.Logger
+	// Reconnect enables transparent reconnection when the connection is lost, nil disables it
+	Reconnect *ReconnectPolicy
+	// PDU length and parallel jobs requested when connecting, 0 requests the defaults (480, 1, 1).
+	// The PLC may grant less, the granted values are in PDULength, MaxAmqCaller and MaxAmqCallee.
+	PDULengthRequested    int
+	MaxAmqCallerRequested int
+	MaxAmqCalleeRequested int
+	// Pipelined keeps up to MaxAmqCallee jobs in flight on the connection instead of one,
+	// so concurrent requests on the same Client run in parallel. Set it before Connect.
+	Pipelined bool
+
+	// Dial opens the connection to Address, e.g. through a proxy or tunnel.
+	// nil dials TCP directly, DialLocal binds to a local address.
+	Dial DialFunc
+	// Capture writes all traffic of the connection, from the ISO connection
+	// request on, to a pcapng file. Set it before Connect, nil disables it.
+	Capture *PcapWriter
+	// Observer receives the metrics of every job sent, nil disables them.
+	Observer Observer
+
+	plainLog plainLog // Logger adapted to slog
 
 	// TCP connection
 	mu           sync.Mutex
 	conn         net.Conn
+	pipe         *pipeline
 	closeTimer   *time.Timer
 	lastActivity time.Time
 
-	localTSAP, remoteTSAP uint16
+	localTSAP, remoteTSAP TSAP
 
-	localTSAPHigh, localTSAPLow   byte
-	remoteTSAPHigh, remoteTSAPLow byte
-	ConnectionType                int
-	LastPDUType                   byte
+	ConnectionType int
+	LastPDUType    byte
+	tpduSize       int // negotiated COTP TPDU size, 0 until the CC is received
 
-	PDULength int
+	// Negotiated session parameters, valid after Connect
+	PDULength    int
+	MaxAmqCaller int
+	MaxAmqCallee int
 }
 
 func (mb *tcpTransporter) setConnectionParameters(address string, localTSAP uint16, remoteTSAP uint16) {
-	locTSAP := localTSAP & 0x0000FFFF
-	remTSAP := remoteTSAP & 0x0000FFFF
+	mb.setTSAPs(address, TSAP{byte(localTSAP >> 8), byte(localTSAP)}, TSAP{byte(remoteTSAP >> 8), byte(remoteTSAP)})
+}
+
+func (mb *tcpTransporter) setTSAPs(address string, localTSAP, remoteTSAP TSAP) {
 	if len(strings.Split(address, ":")) < 2 {
 		mb.Address = address + ":" + strconv.Itoa(isoTCP) //ip:102
 	} else {
 		mb.Address = address
 	}
-	mb.localTSAPHigh = byte(locTSAP >> 8)
-	mb.localTSAPLow = byte(locTSAP & 0x00FF)
-	mb.remoteTSAPHigh = byte(remTSAP >> 8)
-	mb.remoteTSAPLow = byte(remTSAP & 0x00FF)
+	mb.localTSAP = append(TSAP(nil), localTSAP...)
+	mb.remoteTSAP = append(TSAP(nil), remoteTSAP...)
 }
 
 // Send sends data to server and ensures response length is greater than header length.
 func (mb *tcpTransporter) Send(request []byte) (response []byte, err error) {
+	return mb.SendContext(context.Background(), request)
+}
+
+// SendContext is like Send, but a done ctx interrupts the pending socket
+// read/write, and a ctx deadline overrides Timeout for this request.
+// A request aborted by ctx closes the connection, as the stream is no longer in sync.
+func (mb *tcpTransporter) SendContext(ctx context.Context, request []byte) (response []byte, err error) {
+	var retries int
+	if mb.Observer != nil {
+		start := time.Now()
+		defer func() { mb.observe(ctx, request, response, start, retries, err) }()
+	}
+	if mb.Pipelined {
+		return mb.sendPipelined(ctx, request, &retries)
+	}
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
+	if mb.Reconnect != nil {
+		return mb.sendReconnect(ctx, request, &retries)
+	}
+	return mb.send(ctx, request)
+}
+
+// send performs one request/response round-trip. Caller must hold the mutex.
+func (mb *tcpTransporter) send(ctx context.Context, request []byte) (response []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
 	// Set timer to close when idle
 	mb.lastActivity = time.Now()
 	mb.startCloseTimer()
-	// Set write and read timeout
-	var timeout time.Time
-	if mb.Timeout > 0 {
-		timeout = mb.lastActivity.Add(mb.Timeout)
-	}
 	if mb.conn == nil {
 		err = fmt.Errorf("Connection to address %s is null", mb.Address)
 		return
 	}
-	if err = mb.conn.SetDeadline(timeout); err != nil {
+	// Set write and read timeout
+	if err = mb.conn.SetDeadline(mb.deadline(ctx)); err != nil {
 		return
 	}
-	tcpConn := mb.conn.(*net.TCPConn)
-	setTCPOptions(tcpConn)
+	conn := mb.conn
+	stop := context.AfterFunc(ctx, func() {
+		// Unblock the pending read/write immediately
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer func() {
+		stop()
+		if err == nil {
+			return
+		}
+		if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			<-ctx.Done() // the socket timed out on the ctx deadline
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			mb.close()
+		}
+	}()
 	// Send data
-	mb.logf("s7: sending % x", request)
-	if _, err = tcpConn.Write(request); err != nil {
+	start := time.Now()
+	defer func() { mb.logExchange(request, response, start, err) }()
+	if err = write(mb.conn, request, mb.tpduSize); err != nil {
 		return
 	}
-	done := false
-	data := make([]byte, tcpMaxLength)
-	length := 0
-	for !done && err == nil {
-		// Get TPKT (4 bytes)
-		if _, err = io.ReadFull(mb.conn, data[:4]); err != nil {
-			log.Printf("%T %+v", err, err)
+	for {
+		if response, err = receive(mb.conn, mb.maxPduLength(), mb.tpduSize); err != nil {
 			return
 		}
-		// Read length, ignore transaction & protocol id (4 bytes)
-		length = int(binary.BigEndian.Uint16(data[2:]))
-		if length == isoHSize {
-			_, err = io.ReadFull(mb.conn, data[4:7])
-			if err != nil { // Skip remaining 3 bytes and Done is still false
-				return
-			}
-		} else {
-			if length > pduSizeRequested+isoHSize || length < minPduSize {
-				err = fmt.Errorf("s7: invalid pdu")
-				return
-			}
-			done = true
+		if !isS7PDU(request) || !isS7PDU(response) || bytes.Equal(request[11:13], response[11:13]) {
+			break
 		}
+		// the late response of a request which timed out
+		mb.log(slog.LevelWarn, "s7: dropping stale response", "pdu_reference", binary.BigEndian.Uint16(response[11:]))
 	}
-	// Skip remaining 3 COTP bytes
-	_, err = io.ReadFull(mb.conn, data[4:7])
-	if err != nil {
-		return
-	}
-	mb.LastPDUType = data[5] // Stores PDU Type, we need it
-	// Receives the S7 Payload
-	_, err = io.ReadFull(mb.conn, data[7:length])
-	if err != nil {
-		return
-	}
-	response = data[0:length]
-	mb.logf("s7: received % x\n", response)
+	mb.LastPDUType = response[5] // Stores PDU Type, we need it
 	return
 }
 
+// SessionParams reports the parameters negotiated by the last Connect.
+func (mb *tcpTransporter) SessionParams() SessionParams {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return SessionParams{
+		PDULength:      mb.PDULength,
+		MaxAmqCaller:   mb.MaxAmqCaller,
+		MaxAmqCallee:   mb.MaxAmqCallee,
+		ConnectionType: mb.ConnectionType,
+	}
+}
+
+// connected reports whether the connection is established.
+func (mb *tcpTransporter) connected() bool {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.conn != nil
+}
+
 // Connect establishes a new connection to the address in Address.
 // Connect and Close are exported so that multiple requests can be done with one session
 func (mb *tcpTransporter) Connect() error {
-	// mb.mu.Lock()
-	// defer mb.mu.Unlock()
+	return mb.ConnectContext(context.Background())
+}
 
-	return mb.connect()
+// ConnectContext is like Connect, but the whole connect sequence is bounded by ctx.
+func (mb *tcpTransporter) ConnectContext(ctx context.Context) error {
+	return mb.connect(ctx)
 }
 func (mb *tcpTransporter) tcpConnect() error {
+	return mb.tcpConnectContext(context.Background())
+}
+func (mb *tcpTransporter) tcpConnectContext(ctx context.Context) error {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
-	if mb.conn == nil {
-		dialer := net.Dialer{Timeout: mb.Timeout}
-		conn, err := dialer.Dial("tcp", mb.Address)
-		if err != nil {
-			if conn != nil {
-				_ = conn.Close()
-			}
-			return err
+	return mb.dial(ctx)
+}
+
+// dial opens the connection if there is none. Caller must hold the mutex.
+func (mb *tcpTransporter) dial(ctx context.Context) error {
+	if mb.conn != nil {
+		return nil
+	}
+	if _, ok := ctx.Deadline(); !ok && mb.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mb.Timeout)
+		defer cancel()
+	}
+	dial := mb.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", mb.Address)
+	if err != nil {
+		if conn != nil {
+			_ = conn.Close()
 		}
-		mb.conn = conn
+		return err
 	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		setTCPOptions(tcpConn)
+	}
+	mb.conn = mb.capture(conn)
 	return nil
 }
-func (mb *tcpTransporter) connect() error {
+
+// capture returns conn with its traffic written to Capture, if set.
+func (mb *tcpTransporter) capture(conn net.Conn) net.Conn {
+	if mb.Capture == nil {
+		return conn
+	}
+	return mb.Capture.Conn(conn)
+}
+
+func (mb *tcpTransporter) connect(ctx context.Context) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.handshake(ctx)
+}
+
+// handshake runs the three connect stages. Caller must hold the mutex.
+func (mb *tcpTransporter) handshake(ctx context.Context) error {
 	//first stage: TCP connection
-	err := mb.tcpConnect()
+	err := mb.dial(ctx)
 	if err != nil {
+		mb.log(slog.LevelError, "s7: connect failed", "error", err)
 		return err
 	}
 	//second stage: ISOTCP (ISO 8073) Connection
-	err = mb.isoConnect()
+	err = mb.isoConnect(ctx)
+	if err == nil {
+		// Third stage : S7 protocol data unit negotiation
+		err = mb.negotiatePduLength(ctx)
+	}
 	if err != nil {
-		if mb.conn != nil {
-			_ = mb.conn.Close()
-		}
+		mb.close()
+		mb.log(slog.LevelError, "s7: connect failed", "error", err)
 		return err
 	}
-	// Third stage : S7 protocol data unit negotiation
-	return mb.negotiatePduLength()
-
+	if mb.Pipelined {
+		mb.pipe = newPipeline(mb, mb.conn)
+	}
+	args := []any{"local_tsap", mb.localTSAP.String(), "remote_tsap", mb.remoteTSAP.String()}
+	if len(mb.remoteTSAP) == 2 {
+		args = append(args, "rack", mb.remoteTSAP[1]>>5, "slot", mb.remoteTSAP[1]&0x1F)
+	}
+	mb.log(slog.LevelInfo, "s7: connected", append(args,
+		"pdu_length", mb.PDULength, "max_amq_caller", mb.MaxAmqCaller, "max_amq_callee", mb.MaxAmqCallee)...)
+	return nil
 }
 
-// --- 新增带本地绑定的导出方法 ---
+// ConnectWithLocal connects like Connect from the given local address and port,
+// 0 lets the system choose the port. Reconnects use the same local address.
 func (mb *tcpTransporter) ConnectWithLocal(localAddr string, localPort int) error {
-	return mb.connectWithLocal(localAddr, localPort)
+	mb.mu.Lock()
+	mb.Dial = DialLocal(localAddr, localPort)
+	mb.mu.Unlock()
+	return mb.Connect()
+}
+
+// ConnectConn runs the ISO connect and the PDU negotiation over conn, an already
+// established connection such as one end of net.Pipe or an SSH channel. The
+// transporter owns conn afterwards. Reconnects use Dial, as conn can't be reopened.
+func (mb *tcpTransporter) ConnectConn(conn net.Conn) error {
+	return mb.ConnectConnContext(context.Background(), conn)
 }
 
-// --- 内部方法：仅带本地绑定的TCP拨号 ---
-func (mb *tcpTransporter) tcpConnectWithLocal(localAddr string, localPort int) error {
+// ConnectConnContext is the context-aware variant of ConnectConn
+func (mb *tcpTransporter) ConnectConnContext(ctx context.Context, conn net.Conn) error {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
-	if mb.conn == nil {
-		dialer := net.Dialer{
-			LocalAddr: &net.TCPAddr{
-				IP:   net.ParseIP(localAddr),
-				Port: localPort,
-			},
-			Timeout: mb.Timeout,
-		}
-		conn, err := dialer.Dial("tcp", mb.Address)
-		if err != nil {
-			if conn != nil {
-				_ = conn.Close()
-			}
-			return err
-		}
-		mb.conn = conn
-	}
-	return nil
+	mb.close()
+	mb.conn = mb.capture(conn)
+	return mb.handshake(ctx)
 }
 
-// --- 内部方法：带本地绑定的完整握手连接 ---
-func (mb *tcpTransporter) connectWithLocal(localAddr string, localPort int) error {
-	err := mb.tcpConnectWithLocal(localAddr, localPort)
+func (mb *tcpTransporter) isoConnect(ctx context.Context) error {
+	msg := isoConnectionRequest(mb.localTSAP, mb.remoteTSAP)
+
+	// The TPDU size is negotiated by the CR/CC exchange
+	mb.tpduSize = 0
+	// Sends the connection request telegram
+	response, err := mb.send(ctx, msg)
 	if err != nil {
 		return err
 	}
-	err = mb.isoConnect()
-	if err != nil {
-		if mb.conn != nil {
-			_ = mb.conn.Close()
-		}
-		return err
+	if len(response) < 11 {
+		return fmt.Errorf(ErrorText(errIsoInvalidPDU))
 	}
-	return mb.negotiatePduLength()
-}
-
-func (mb *tcpTransporter) isoConnect() error {
-	msg := make([]byte, len(isoConnectionRequestTelegram))
-	copy(msg, isoConnectionRequestTelegram)
-	msg[16] = mb.localTSAPHigh
-	msg[17] = mb.localTSAPLow
-	msg[20] = mb.remoteTSAPHigh
-	msg[21] = mb.remoteTSAPLow
-
-	// Sends the connection request telegram
-	response, err := mb.Send(msg)
-	if size := len(response); size == 22 {
-		if mb.LastPDUType != byte(0xD0) { // 0xD0 = CC Connection confirm
-			err = fmt.Errorf("errIsoConnect")
-		}
-	} else {
-		err = fmt.Errorf(ErrorText(errIsoInvalidPDU))
+	if mb.LastPDUType != cotpCC {
+		return fmt.Errorf(ErrorText(errIsoConnect))
 	}
-	return err
+	// The confirmed TPDU size, or the proposed one if the CC has none
+	if mb.tpduSize = tpduSizeParam(response); mb.tpduSize == 0 {
+		mb.tpduSize = tpduSizeParam(msg)
+	}
+	return nil
 }
-func (mb *tcpTransporter) negotiatePduLength() error {
+func (mb *tcpTransporter) negotiatePduLength(ctx context.Context) error {
 	// Set PDU Size Requested //lth
+	pduLength, amqCaller, amqCallee := mb.requestedSession()
+	if pduLength < minPduLength || pduLength > 0xFFFF-isoHSize || amqCaller > 0xFFFF || amqCallee > 0xFFFF {
+		return fmt.Errorf(ErrorText(errCliInvalidParams))
+	}
 	pduSizePackage := make([]byte, len(s7PDUNegogiationTelegram))
 	copy(pduSizePackage, s7PDUNegogiationTelegram)
-	binary.BigEndian.PutUint16(pduSizePackage[23:], uint16(pduSizeRequested))
+	binary.BigEndian.PutUint16(pduSizePackage[19:], uint16(amqCaller))
+	binary.BigEndian.PutUint16(pduSizePackage[21:], uint16(amqCallee))
+	binary.BigEndian.PutUint16(pduSizePackage[23:], uint16(pduLength))
 	// Sends the connection request telegram
-	response, err := mb.Send(pduSizePackage)
+	response, err := mb.send(ctx, pduSizePackage)
 	length := len(response)
 	if length == 27 && response[17] == 0 && response[18] == 0 { // 20 = size of Negotiate Answer
-		// Get PDU Size Negotiated
+		// Get PDU Size and parallel jobs Negotiated
+		mb.MaxAmqCaller = int(binary.BigEndian.Uint16(response[21:]))
+		mb.MaxAmqCallee = int(binary.BigEndian.Uint16(response[23:]))
 		mb.PDULength = int(binary.BigEndian.Uint16(response[25:]))
 		if mb.PDULength <= 0 {
 			err = fmt.Errorf(ErrorText(errCliNegotiatingPDU))
@@ -319,6 +422,43 @@ func (mb *tcpTransporter) negotiatePduLength() error {
 	}
 	return err
 }
+
+// requestedSession returns the PDU length and parallel jobs to request from the PLC.
+func (mb *tcpTransporter) requestedSession() (pduLength, amqCaller, amqCallee int) {
+	pduLength, amqCaller, amqCallee = pduSizeRequested, amqRequested, amqRequested
+	if mb.PDULengthRequested > 0 {
+		pduLength = mb.PDULengthRequested
+	}
+	if mb.MaxAmqCallerRequested > 0 {
+		amqCaller = mb.MaxAmqCallerRequested
+	}
+	if mb.MaxAmqCalleeRequested > 0 {
+		amqCallee = mb.MaxAmqCalleeRequested
+	}
+	return
+}
+
+// maxPduLength returns the largest S7 PDU accepted from the PLC: the granted PDU
+// length once negotiated, the requested one during the negotiation.
+func (mb *tcpTransporter) maxPduLength() int {
+	if mb.PDULength > 0 {
+		return mb.PDULength
+	}
+	pduLength, _, _ := mb.requestedSession()
+	return pduLength
+}
+
+// deadline returns the I/O deadline for a request, the ctx deadline has priority over Timeout.
+func (mb *tcpTransporter) deadline(ctx context.Context) (t time.Time) {
+	if d, ok := ctx.Deadline(); ok {
+		return d
+	}
+	if mb.Timeout > 0 {
+		t = mb.lastActivity.Add(mb.Timeout)
+	}
+	return
+}
+
 func (mb *tcpTransporter) startCloseTimer() {
 	if mb.IdleTimeout <= 0 {
 		return
@@ -355,14 +495,14 @@ func (mb *tcpTransporter) flush(b []byte) (err error) {
 	return
 }
 
-func (mb *tcpTransporter) logf(format string, v ...interface{}) {
-	if mb.Logger != nil {
-		mb.Logger.Printf(format, v...)
-	}
-}
-
 // closeLocked closes current connection. Caller must hold the mutex before calling this method.
 func (mb *tcpTransporter) close() (err error) {
+	if mb.pipe != nil {
+		// the pipeline owns the connection and fails its pending jobs
+		mb.pipe.fail(fmt.Errorf("s7: connection to %s closed", mb.Address))
+		mb.pipe = nil
+		mb.conn = nil
+	}
 	if mb.conn != nil {
 		err = mb.conn.Close()
 		mb.conn = nil
@@ -380,12 +520,7 @@ func (mb *tcpTransporter) closeIdle() {
 	}
 	idle := time.Since(mb.lastActivity)
 	if idle >= mb.IdleTimeout {
-		mb.logf("s7: closing connection due to idle timeout: %v", idle)
+		mb.log(slog.LevelInfo, "s7: closing idle connection", "idle", idle)
 		mb.close()
 	}
 }
-
-// reserve for future use, need to verify the request and response
-func (mb *tcpPackager) Verify(request []byte, response []byte) (err error) {
-	return
-}
@@ -5,8 +5,14 @@ package gos7
 // of the BSD license. See the LICENSE file for details.
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -51,3 +57,469 @@ func TestTCPTransporter(t *testing.T) {
 		t.Fatalf("connection is not closed: %+v", client.conn)
 	}
 }
+
+func TestTCPTransporterSendContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// never answer
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	client := &tcpTransporter{
+		Address: ln.Addr().String(),
+		Timeout: 200 * time.Second,
+	}
+	req := []byte{0, 1, 0, 17, 0, 2, 1, 2, 0, 1, 0, 17, 0, 2, 1, 2, 2}
+
+	if err = client.tcpConnect(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	begin := time.Now()
+	if _, err = client.SendContext(ctx, req); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Fatalf("cancel took %v", elapsed)
+	}
+	if client.conn != nil {
+		t.Fatalf("connection is not closed: %+v", client.conn)
+	}
+
+	// a ctx deadline overrides Timeout
+	if err = client.tcpConnect(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = client.SendContext(ctx, req); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// serveFakePLC serves every accepted connection with serveFakeConn.
+func serveFakePLC(ln net.Listener, job func(request []byte) []byte) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go serveFakeConn(conn, job)
+	}
+}
+
+// serveFakeConn answers the ISO connect and the PDU negotiation and passes all
+// other requests to job, which returns the response or nil to drop the
+// connection. Jobs run concurrently.
+func serveFakeConn(conn net.Conn, job func(request []byte) []byte) {
+	defer conn.Close()
+	var wmu sync.Mutex
+	reply := func(response []byte) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		if response == nil {
+			conn.Close()
+			return
+		}
+		conn.Write(response)
+	}
+	for {
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		request = append(request, make([]byte, int(request[2])<<8+int(request[3])-4)...)
+		if _, err := io.ReadFull(conn, request[4:]); err != nil {
+			return
+		}
+		switch {
+		case request[5] == 0xE0: // connection request
+			reply([]byte{3, 0, 0, 22, 17, 0xD0, 0, 1, 0, 1, 0, 0xC0, 1, 10, 0xC1, 2, 1, 0, 0xC2, 2, 1, 2})
+		case request[17] == 0xF0: // setup communication, the requested amq is granted
+			reply([]byte{3, 0, 0, 27, 2, 0xF0, 0x80, 0x32, 3, 0, 0, request[11], request[12], 0, 8, 0, 0, 0, 0,
+				0xF0, 0, request[19], request[20], request[21], request[22], 0x01, 0xE0})
+		default:
+			go func() { reply(job(request)) }()
+		}
+	}
+}
+
+func TestTCPTransporterReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var jobs atomic.Int32
+	// every odd job drops the connection without an answer
+	go serveFakePLC(ln, func(request []byte) []byte {
+		if jobs.Add(1)%2 == 1 {
+			return nil
+		}
+		return []byte{3, 0, 0, 27, 2, 0xF0, 0x80, 0x32, 3, 0, 0, request[11], request[12], 0, 2, 0, 6, 0, 0,
+			4, 1, 0xFF, 4, 0, 16, 0x12, 0x34}
+	})
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	handler.Reconnect = &ReconnectPolicy{MaxRetries: 2, InitialBackoff: 10 * time.Millisecond}
+	if err = handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	client := NewClient(handler)
+
+	buf := make([]byte, 2)
+	if err = client.AGReadDB(1, 0, 2, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, []byte{0x12, 0x34}) {
+		t.Fatalf("unexpected data: %x", buf)
+	}
+	err = client.AGWriteDB(1, 0, 2, buf)
+	var lost *ConnectionLostError
+	if !errors.As(err, &lost) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTCPTransporterPipelined(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	const jobs = 4
+	// hold the responses until all jobs arrived, then answer in reverse order
+	var arrived sync.WaitGroup
+	arrived.Add(jobs)
+	go serveFakePLC(ln, func(request []byte) []byte {
+		db := int(binary.BigEndian.Uint16(request[25:]))
+		arrived.Done()
+		arrived.Wait()
+		time.Sleep(time.Duration(jobs-db) * 10 * time.Millisecond)
+		return []byte{3, 0, 0, 27, 2, 0xF0, 0x80, 0x32, 3, 0, 0, request[11], request[12], 0, 2, 0, 6, 0, 0,
+			4, 1, 0xFF, 4, 0, 16, request[25], request[26]}
+	})
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	handler.MaxAmqCalleeRequested = jobs
+	handler.Pipelined = true
+	if err = handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	if params := handler.SessionParams(); params.PDULength != 480 || params.MaxAmqCallee != jobs {
+		t.Fatalf("unexpected session: %+v", params)
+	}
+	client := NewClient(handler)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, jobs)
+	for db := 1; db <= jobs; db++ {
+		wg.Add(1)
+		go func(db int) {
+			defer wg.Done()
+			buf := make([]byte, 2)
+			if err := client.AGReadDB(db, 0, 2, buf); err != nil {
+				errs <- err
+			} else if int(binary.BigEndian.Uint16(buf)) != db {
+				errs <- fmt.Errorf("DB%d: unexpected data: %x", db, buf)
+			}
+		}(db)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestCOTPSegmentation(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	request := []byte{3, 0, 0, 31, 2, 0xF0, 0x80, 0x32, 1, 0, 0, 0, 1, 0, 14, 0, 0,
+		4, 1, 0x12, 10, 0x10, 2, 0, 2, 0, 1, 0x84, 0, 0, 0}
+	// 16 byte TPDUs carry 13 bytes of the 24 byte S7 PDU each
+	go write(client, request, 16)
+	response, err := receive(server, 480, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(request, response) {
+		t.Fatalf("unexpected response: %x", response)
+	}
+	go write(client, request, 0)
+	if _, err = receive(server, 480, 16); err == nil {
+		t.Fatal("TPDU exceeding the negotiated size accepted")
+	}
+
+	// DR with reason "address unknown"
+	go client.Write([]byte{3, 0, 0, 11, 6, 0x80, 0, 1, 0, 2, 3})
+	_, err = receive(server, 480, 16)
+	var dr *DisconnectError
+	if !errors.As(err, &dr) || dr.Reason != 3 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestISOConnectionRequestTSAP(t *testing.T) {
+	if msg := isoConnectionRequest(TSAP{1, 0}, TSAP{1, 2}); !bytes.Equal(msg, isoConnectionRequestTelegram) {
+		t.Fatalf("unexpected telegram: %x", msg)
+	}
+	remote, err := ParseTSAP("03.01")
+	if err != nil || !bytes.Equal(remote, []byte{3, 1}) {
+		t.Fatalf("unexpected TSAP: %v %v", remote, err)
+	}
+	local, err := ParseTSAP("0x4D5750")
+	if err != nil || local.String() != "4D.57.50" {
+		t.Fatalf("unexpected TSAP: %v %v", local, err)
+	}
+	msg := isoConnectionRequest(local, remote)
+	want := []byte{3, 0, 0, 23, 18, 0xE0, 0, 0, 0, 1, 0, 0xC0, 1, 10, 0xC1, 3, 0x4D, 0x57, 0x50, 0xC2, 2, 3, 1}
+	if !bytes.Equal(msg, want) {
+		t.Fatalf("unexpected telegram: %x", msg)
+	}
+	if tpduSizeParam(msg) != 1024 {
+		t.Fatalf("unexpected TPDU size: %d", tpduSizeParam(msg))
+	}
+}
+
+func TestRoutingTSAP(t *testing.T) {
+	h, err := NewTCPClientHandlerWithRouting("192.168.0.1", Routing{SubnetID: 0x01520013, Node: []byte{2}, Rack: 0, Slot: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TSAP{1, 6, 1, 2, 0x01, 0x52, 0, 0, 0x00, 0x13, 2}
+	want = append(want, make([]byte, 16)...)
+	want = append(want, connectionTypePG, 2)
+	if !bytes.Equal(h.remoteTSAP, want) {
+		t.Fatalf("unexpected remote TSAP: %v", h.remoteTSAP)
+	}
+	if msg := isoConnectionRequest(h.localTSAP, h.remoteTSAP); len(msg) != 76 || msg[4] != 71 {
+		t.Fatalf("unexpected telegram: %x", msg)
+	}
+}
+
+// writtenConn records what is written to the connection.
+type writtenConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *writtenConn) Write(b []byte) (int, error) {
+	c.written.Write(b)
+	return c.Conn.Write(b)
+}
+
+func TestRoutedConnection(t *testing.T) {
+	server := NewServer()
+	server.SetDB(1, []byte{0x12, 0x34})
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	h, err := NewTCPClientHandlerWithRouting(server.Addr().String(), Routing{SubnetID: 0x01520013, Node: []byte{2}, Rack: 0, Slot: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conn *writtenConn
+	h.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		c, err := (&net.Dialer{}).DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		conn = &writtenConn{Conn: c}
+		return conn, nil
+	}
+	if err = h.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	buf := make([]byte, 2)
+	if err = NewClient(h).AGReadDB(1, 0, 2, buf); err != nil || !bytes.Equal(buf, []byte{0x12, 0x34}) {
+		t.Fatalf("unexpected read: %x %v", buf, err)
+	}
+	if request := isoConnectionRequest(h.localTSAP, h.remoteTSAP); !bytes.HasPrefix(conn.written.Bytes(), request) {
+		t.Fatalf("unexpected connection request: %x", conn.written.Bytes())
+	}
+}
+
+// szlResponse returns the answer to an SZL read request with the given data records.
+func szlResponse(request []byte, records ...byte) []byte {
+	length := 41 + len(records)
+	response := []byte{3, 0, byte(length >> 8), byte(length), 2, 0xF0, 0x80, 0x32, 7, 0, 0, request[11], request[12],
+		0, 12, byte((12 + len(records)) >> 8), byte(12 + len(records)), 0, 1, 0x12, 8, 0x12, 0x84, 1, 1, 0, 0, 0, 0,
+		0xFF, 9, byte((8 + len(records)) >> 8), byte(8 + len(records)), request[29], request[30], 0, 0, 0, byte(len(records)), 0, 1}
+	return append(response, records...)
+}
+
+func TestRedundantClientHandlerFailover(t *testing.T) {
+	var master atomic.Int32 // rack of the master CPU
+	var down [2]atomic.Bool
+	var listeners [2]net.Listener
+	for rack := range listeners {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		listeners[rack] = ln
+		rack := rack
+		go serveFakePLC(ln, func(request []byte) []byte {
+			if down[rack].Load() {
+				return nil
+			}
+			switch {
+			case request[8] == 7 && request[29] == 4: // SZL 0x0424: CPU status RUN
+				return szlResponse(request, 0, 0, 0, s7CpuStatusRun)
+			case request[8] == 7: // SZL 0x0071: H CPU group information
+				return szlResponse(request, 0, 0x12, byte(hMasterRack0<<master.Load()), 0)
+			}
+			return []byte{3, 0, 0, 27, 2, 0xF0, 0x80, 0x32, 3, 0, 0, request[11], request[12], 0, 2, 0, 6, 0, 0,
+				4, 1, 0xFF, 4, 0, 16, 0, byte(rack)}
+		})
+	}
+	handler := NewRedundantClientHandler(
+		RedundantPath{Address: listeners[0].Addr().String(), Rack: 0, Slot: 3},
+		RedundantPath{Address: listeners[1].Addr().String(), Rack: 1, Slot: 3},
+	)
+	var events []FailoverEvent
+	handler.OnFailover = func(event FailoverEvent) {
+		events = append(events, event)
+	}
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	client := NewClient(handler)
+	buf := make([]byte, 2)
+	if err := client.AGReadDB(1, 0, 2, buf); err != nil || buf[1] != 0 {
+		t.Fatalf("unexpected read from rack %d: %v", buf[1], err)
+	}
+
+	// switchover: the CPU in rack 0 fails, rack 1 becomes master
+	master.Store(1)
+	down[0].Store(true)
+	if err := client.AGReadDB(1, 0, 2, buf); err != nil || buf[1] != 1 {
+		t.Fatalf("unexpected read from rack %d: %v", buf[1], err)
+	}
+	if handler.ActivePath() != 1 || len(events) != 1 || events[0].From != 0 || events[0].To != 1 || events[0].Err == nil {
+		t.Fatalf("unexpected failover: %d %+v", handler.ActivePath(), events)
+	}
+}
+
+func TestIsHMaster(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var drop atomic.Bool
+	go serveFakePLC(ln, func(request []byte) []byte {
+		switch {
+		case request[29] == 4: // SZL 0x0424: CPU status RUN
+			return szlResponse(request, 0, 0, 0, s7CpuStatusRun)
+		case drop.Load():
+			return nil
+		}
+		// a CPU which is not part of an H-system does not know SZL 0x0071
+		response := szlResponse(request)
+		response[27], response[28], response[29] = 0xD4, 0x01, code7ResItemNotAvailable
+		return response
+	})
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	if err = handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if master, err := isHMaster(context.Background(), handler, 0); !master || err != nil {
+		t.Fatalf("unexpected probe of a single CPU: %v %v", master, err)
+	}
+	drop.Store(true)
+	if master, err := isHMaster(context.Background(), handler, 0); master || err == nil {
+		t.Fatalf("unexpected probe of a lost CPU: %v %v", master, err)
+	}
+}
+
+func TestTCPTransporterCustomConn(t *testing.T) {
+	job := func(request []byte) []byte {
+		return []byte{3, 0, 0, 27, 2, 0xF0, 0x80, 0x32, 3, 0, 0, request[11], request[12], 0, 2, 0, 6, 0, 0,
+			4, 1, 0xFF, 4, 0, 16, 0x12, 0x34}
+	}
+	// an established connection
+	conn, plc := net.Pipe()
+	go serveFakeConn(plc, job)
+	handler := NewTCPClientHandler("plc", 0, 2)
+	if err := handler.ConnectConn(conn); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if err := NewClient(handler).AGReadDB(1, 0, 2, buf); err != nil || !bytes.Equal(buf, []byte{0x12, 0x34}) {
+		t.Fatalf("unexpected read: %x %v", buf, err)
+	}
+	handler.Close()
+
+	// a custom dialer
+	handler.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if address != "plc:102" {
+			t.Errorf("unexpected address: %s", address)
+		}
+		conn, plc := net.Pipe()
+		go serveFakeConn(plc, job)
+		return conn, nil
+	}
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	if err := NewClient(handler).AGReadDB(1, 0, 2, buf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNegotiatePDULength(t *testing.T) {
+	conn, plc := net.Pipe()
+	setup := make(chan []byte, 1)
+	go func() {
+		defer plc.Close()
+		for {
+			request := make([]byte, 4)
+			if _, err := io.ReadFull(plc, request); err != nil {
+				return
+			}
+			request = append(request, make([]byte, int(request[2])<<8+int(request[3])-4)...)
+			if _, err := io.ReadFull(plc, request[4:]); err != nil {
+				return
+			}
+			if request[5] == 0xE0 {
+				plc.Write([]byte{3, 0, 0, 22, 17, 0xD0, 0, 1, 0, 1, 0, 0xC0, 1, 10, 0xC1, 2, 1, 0, 0xC2, 2, 1, 2})
+				continue
+			}
+			setup <- request
+			// the PLC grants less than requested
+			plc.Write([]byte{3, 0, 0, 27, 2, 0xF0, 0x80, 0x32, 3, 0, 0, request[11], request[12], 0, 8, 0, 0, 0, 0,
+				0xF0, 0, 0, 2, 0, 3, 0, 240})
+		}
+	}()
+	handler := NewTCPClientHandler("plc", 0, 2)
+	handler.PDULengthRequested = 960
+	handler.MaxAmqCallerRequested = 4
+	handler.MaxAmqCalleeRequested = 5
+	if err := handler.ConnectConn(conn); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	if request := <-setup; !bytes.Equal(request[19:25], []byte{0, 4, 0, 5, 0x03, 0xC0}) {
+		t.Fatalf("unexpected setup communication: %x", request)
+	}
+	if params := handler.SessionParams(); params.PDULength != 240 || params.MaxAmqCaller != 2 || params.MaxAmqCallee != 3 {
+		t.Fatalf("unexpected session: %+v", params)
+	}
+}
@@ -32,16 +32,15 @@ func setTCPOptions(tcpConn *net.TCPConn) {
 		byte(timestamp),
 	})
 	// 设置TCP选项
-	// 获取连接的文件描述符
-	fd, err := tcpConn.File()
+	// 不能使用 tcpConn.File()：它会把连接切换为阻塞模式，读写超时(deadline)将失效
+	rawConn, err := tcpConn.SyscallConn()
 	if err != nil {
 		return
 	}
-	defer fd.Close()
-	// 在 Unix 系统上，fd.Fd() 返回 int
-	fdInt := int(fd.Fd())
-	// 设置 TCP 保活选项 (SO_KEEPALIVE)
-	_ = syscall.SetsockoptInt(fdInt, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1)
+	_ = rawConn.Control(func(fd uintptr) {
+		// 设置 TCP 保活选项 (SO_KEEPALIVE)
+		_ = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1)
+	})
 	// 注意：TCP 时间戳选项在 Unix 系统上通常需要更底层的操作
 	// 这里简化处理，只设置基本的 keepalive
 }
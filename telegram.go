@@ -256,6 +256,12 @@ var s7BlockInfoTelegram = []byte{
 var s7PGBlockListTelegram = []byte{
 	3, 0, 0, 31, 2, 240, 128, 50, 7, 0, 0, 5, 0, 0, 8, 0, 6, 0, 1, 18, 4, 17, 67, 2, 0, 255, 9, 0, 2, 48}
 
+// S7 Get Block List next part request
+var s7PGBlockListNextTelegram = []byte{
+	3, 0, 0, 33, 2, 240, 128, 50, 7, 0, 0, 6, 0, 0, 12, 0, 4, 0, 1, 18, 8, 18, 67, 2,
+	1, // Sequence
+	0, 0, 0, 0, 10, 0, 0, 0}
+
 var s7PGBlockDeleteTelegram = []byte{
 	50, 1, 0, 0, 107, 0, 0, 26, 0, 0, 40, 0, 0, 0, 0, 0, 0, 253, 0, 10, 1, 0, 48,
 	0,             //block type, should replace
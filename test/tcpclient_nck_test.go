@@ -45,38 +45,6 @@ func main() {
 
 func readNck(client gos7.Client) error {
 
-	items := []gos7.S7NckAddrItem{{
-		Area:   4,
-		Unit:   1,
-		Column: 3,
-		Line:   3,
-		Module: 0x26,
-	}, {
-		Area:   4,
-		Unit:   1,
-		Column: 1,
-		Line:   3,
-		Module: 0x21,
-	}, {
-		Area:   4,
-		Unit:   1,
-		Column: 14,
-		Line:   3,
-		Module: 0x21,
-	}, {
-		Area:   4,
-		Unit:   1,
-		Column: 17,
-		Line:   3,
-		Module: 0x21,
-	}, {
-		Area:   4,
-		Unit:   1,
-		Column: 3,
-		Line:   3,
-		Module: 0x26,
-	}}
-
 	items2 := []gos7.S7NckAddrItem{
 		{
 			Area:   4,
@@ -0,0 +1,103 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TSAP is a transport service access point as sent in the ISO connection request.
+// S7 CPUs use 2 bytes, connection type in the high byte and rack/slot in the low
+// byte, other devices accept any length.
+type TSAP []byte
+
+// ParseTSAP parses a TSAP in the dotted notation of the Siemens tools ("03.01",
+// "10.00") or as hex number ("0x0200").
+func ParseTSAP(s string) (TSAP, error) {
+	if h := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"); h != s {
+		tsap, err := hex.DecodeString(h)
+		if err != nil || len(tsap) == 0 {
+			return nil, fmt.Errorf("s7: invalid TSAP %q", s)
+		}
+		return tsap, nil
+	}
+	parts := strings.Split(s, ".")
+	tsap := make(TSAP, len(parts))
+	for i, part := range parts {
+		b, err := strconv.ParseUint(part, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("s7: invalid TSAP %q", s)
+		}
+		tsap[i] = byte(b)
+	}
+	return tsap, nil
+}
+
+// String returns the TSAP in dotted notation.
+func (t TSAP) String() string {
+	parts := make([]string, len(t))
+	for i, b := range t {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ".")
+}
+
+// ConnectionOptions describes a connection by its TSAPs instead of rack and slot,
+// e.g. for LOGO! (local 01.00, remote 02.00), S7-200 with CP243 (local 10.00,
+// remote 10.01) or S7-1200 configured connections.
+type ConnectionOptions struct {
+	// Address of the device, port 102 is used if it has none
+	Address string
+	// LocalTSAP is the TSAP of the client, 01.00 if empty
+	LocalTSAP TSAP
+	// RemoteTSAP is the TSAP of the device
+	RemoteTSAP TSAP
+}
+
+// NewTCPClientHandlerWithOptions allocates a new TCPClientHandler connecting with the given TSAPs.
+func NewTCPClientHandlerWithOptions(options ConnectionOptions) (*TCPClientHandler, error) {
+	localTSAP := options.LocalTSAP
+	if len(localTSAP) == 0 {
+		localTSAP = TSAP{0x01, 0x00}
+	}
+	if len(options.RemoteTSAP) == 0 || len(localTSAP)+len(options.RemoteTSAP) > maxTSAPLength {
+		return nil, fmt.Errorf(ErrorText(errCliInvalidParams))
+	}
+	h := &TCPClientHandler{}
+	h.Timeout = tcpTimeout
+	h.IdleTimeout = tcpIdleTimeout
+	if len(options.RemoteTSAP) == 2 {
+		h.ConnectionType = int(options.RemoteTSAP[0])
+	}
+	h.setTSAPs(options.Address, localTSAP, options.RemoteTSAP)
+	return h, nil
+}
+
+// NewTCPClientHandlerWithTSAP allocates a new TCPClientHandler connecting with 2 byte TSAPs.
+func NewTCPClientHandlerWithTSAP(address string, localTSAP, remoteTSAP uint16) *TCPClientHandler {
+	h := &TCPClientHandler{}
+	h.Timeout = tcpTimeout
+	h.IdleTimeout = tcpIdleTimeout
+	h.ConnectionType = int(remoteTSAP >> 8)
+	h.setConnectionParameters(address, localTSAP, remoteTSAP)
+	return h
+}
+
+// isoConnectionRequest returns the ISO connection request telegram for the TSAPs.
+func isoConnectionRequest(localTSAP, remoteTSAP TSAP) []byte {
+	// TPKT, CR header and TPDU size parameter are the first 14 bytes of the telegram
+	msg := make([]byte, 14, 18+len(localTSAP)+len(remoteTSAP))
+	copy(msg, isoConnectionRequestTelegram)
+	msg = append(msg, 0xC1, byte(len(localTSAP)))
+	msg = append(msg, localTSAP...)
+	msg = append(msg, 0xC2, byte(len(remoteTSAP)))
+	msg = append(msg, remoteTSAP...)
+	msg[2] = byte(len(msg) >> 8)
+	msg[3] = byte(len(msg))
+	msg[4] = byte(len(msg) - 5) // LI excludes itself
+	return msg
+}
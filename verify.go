@@ -0,0 +1,131 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/punk-one/gos7/s7comm"
+)
+
+// Errors of responses which do not belong to their request, wrapped in a
+// ResponseMismatchError.
+var (
+	ErrProtocolID   = errors.New("s7: response is not an S7 PDU")
+	ErrROSCTR       = errors.New("s7: response has an unexpected ROSCTR")
+	ErrPDUReference = errors.New("s7: response has another PDU reference")
+	ErrFunction     = errors.New("s7: response has another function")
+	ErrItemCount    = errors.New("s7: response has another item count")
+	ErrLength       = errors.New("s7: response lengths disagree with its frame")
+)
+
+// ResponseMismatchError is returned by Verify when a response does not belong
+// to its request, e.g. a stale response of a request which timed out.
+type ResponseMismatchError struct {
+	Err  error // one of the Err variables above
+	Want int
+	Got  int
+}
+
+func (e *ResponseMismatchError) Error() string {
+	return fmt.Sprintf("%v: expected %d, got %d", e.Err, e.Want, e.Got)
+}
+
+// Unwrap returns the kind of the mismatch.
+func (e *ResponseMismatchError) Unwrap() error {
+	return e.Err
+}
+
+// S7 PDU header
+const (
+	s7ProtocolID    = 0x32
+	s7HeaderSize    = 10 // job and userdata
+	s7AckHeaderSize = 12 // ack and ack_data, with the error class and code
+)
+
+// Verify checks that the response answers the request. Frames which do not
+// hold an S7 job or userdata request, like the COTP connection, are not checked.
+func (mb *tcpPackager) Verify(request []byte, response []byte) (err error) {
+	return verifyResponse(request, response)
+}
+
+func verifyResponse(request, response []byte) error {
+	if len(request) < isoHSize+s7HeaderSize || request[7] != s7ProtocolID {
+		return nil
+	}
+	mismatch := func(err error, want, got int) error {
+		return &ResponseMismatchError{Err: err, Want: want, Got: got}
+	}
+	if len(response) < isoHSize+s7HeaderSize {
+		return mismatch(ErrLength, isoHSize+s7HeaderSize, len(response))
+	}
+	if response[7] != s7ProtocolID {
+		return mismatch(ErrProtocolID, s7ProtocolID, int(response[7]))
+	}
+	// ROSCTR
+	rosctr := response[8]
+	switch request[8] {
+	case s7comm.Job:
+		if rosctr != s7comm.Ack && rosctr != s7comm.AckData {
+			return mismatch(ErrROSCTR, s7comm.AckData, int(rosctr))
+		}
+	case s7comm.UserData:
+		if rosctr != s7comm.UserData {
+			return mismatch(ErrROSCTR, s7comm.UserData, int(rosctr))
+		}
+	}
+	// lengths
+	headerSize := s7HeaderSize
+	if rosctr == s7comm.Ack || rosctr == s7comm.AckData {
+		headerSize = s7AckHeaderSize
+	}
+	if len(response) < isoHSize+headerSize {
+		return mismatch(ErrLength, isoHSize+headerSize, len(response))
+	}
+	paramLength := int(binary.BigEndian.Uint16(response[13:]))
+	dataLength := int(binary.BigEndian.Uint16(response[15:]))
+	if size := isoHSize + headerSize + paramLength + dataLength; size != len(response) {
+		return mismatch(ErrLength, size, len(response))
+	}
+	// PDU reference
+	if want, got := binary.BigEndian.Uint16(request[11:]), binary.BigEndian.Uint16(response[11:]); want != got {
+		return mismatch(ErrPDUReference, int(want), int(got))
+	}
+	if headerSize == s7AckHeaderSize && binary.BigEndian.Uint16(response[17:]) != 0 {
+		// refused with an error class and code, the parameters may be missing
+		return nil
+	}
+	// function and item count
+	reqParam := request[isoHSize+s7HeaderSize:]
+	if n := int(binary.BigEndian.Uint16(request[13:])); n <= len(reqParam) {
+		reqParam = reqParam[:n]
+	}
+	resParam := response[isoHSize+headerSize : isoHSize+headerSize+paramLength]
+	if request[8] == s7comm.UserData {
+		// userdata: the parameters hold type and group in [5], subfunction in [6]
+		if len(reqParam) < 7 || len(resParam) < 7 {
+			return nil
+		}
+		if reqParam[5]&0x0F != resParam[5]&0x0F {
+			return mismatch(ErrFunction, int(reqParam[5]&0x0F), int(resParam[5]&0x0F))
+		}
+		if reqParam[6] != resParam[6] {
+			return mismatch(ErrFunction, int(reqParam[6]), int(resParam[6]))
+		}
+		return nil
+	}
+	if len(reqParam) == 0 || len(resParam) == 0 {
+		return nil
+	}
+	if reqParam[0] != resParam[0] {
+		return mismatch(ErrFunction, int(reqParam[0]), int(resParam[0]))
+	}
+	if (reqParam[0] == s7comm.FuncReadVar || reqParam[0] == s7comm.FuncWriteVar) && len(reqParam) > 1 && len(resParam) > 1 &&
+		reqParam[1] != resParam[1] {
+		return mismatch(ErrItemCount, int(reqParam[1]), int(resParam[1]))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package gos7
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVerifyResponse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var mu sync.Mutex
+	// DB1 answers too late, DB3 with another item count, the data is the DB number
+	go serveFakePLC(ln, func(request []byte) []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		items := byte(1)
+		switch request[26] {
+		case 1:
+			time.Sleep(130 * time.Millisecond)
+		case 3:
+			items = 2
+		}
+		return []byte{3, 0, 0, 27, 2, 0xF0, 0x80, 0x32, 3, 0, 0, request[11], request[12], 0, 2, 0, 6, 0, 0,
+			4, items, 0xFF, 4, 0, 16, request[25], request[26]}
+	})
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	handler.Timeout = 100 * time.Millisecond
+	if err = handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	client := NewClient(handler)
+
+	buf := make([]byte, 2)
+	if err = client.AGReadDB(1, 0, 2, buf); err == nil {
+		t.Fatal("expected timeout")
+	}
+	// the stale response of DB1 arrives first and is dropped
+	if err = client.AGReadDB(2, 0, 2, buf); err != nil || !bytes.Equal(buf, []byte{0, 2}) {
+		t.Fatalf("unexpected read: %x %v", buf, err)
+	}
+	err = client.AGReadDB(3, 0, 2, buf)
+	var mismatch *ResponseMismatchError
+	if !errors.Is(err, ErrItemCount) || !errors.As(err, &mismatch) || mismatch.Want != 1 || mismatch.Got != 2 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request := []byte{3, 0, 0, 19, 2, 0xF0, 0x80, 0x32, 1, 0, 0, 0, 7, 0, 2, 0, 0, 4, 0}
+	response := []byte{3, 0, 0, 21, 2, 0xF0, 0x80, 0x32, 3, 0, 0, 0, 7, 0, 2, 0, 0, 0, 0, 4, 0}
+	if err = handler.Verify(request, response); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		offset int
+		value  byte
+		want   error
+	}{
+		{7, 0x33, ErrProtocolID},
+		{8, 7, ErrROSCTR},
+		{12, 8, ErrPDUReference},
+		{19, 5, ErrFunction},
+		{16, 1, ErrLength},
+	} {
+		bad := append([]byte(nil), response...)
+		bad[c.offset] = c.value
+		if err = handler.Verify(request, bad); !errors.Is(err, c.want) {
+			t.Fatalf("byte %d: unexpected error: %v", c.offset, err)
+		}
+	}
+}